@@ -16,6 +16,13 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "mcp-serve" {
+		if err := runMCPServe(ctx, cwd, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	c, err := chat.New(ctx, cwd)
 	if err != nil {
 		log.Fatal(err)
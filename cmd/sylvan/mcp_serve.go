@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/jmuk/sylvan/pkg/session"
+	"github.com/jmuk/sylvan/pkg/tools"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// runMCPServe runs sylvan as a MCP server exposing the builtin tools, over
+// the standard input and output by default.
+func runMCPServe(ctx context.Context, cwd string, args []string) error {
+	fs := flag.NewFlagSet("mcp-serve", flag.ExitOnError)
+	httpAddr := fs.String("http", "", "serve the Streamable HTTP transport on the address such as localhost:8080, instead of the standard input and output")
+	token := fs.String("token", "", "the bearer token required for the HTTP requests; needed to serve on a non-loopback address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *httpAddr != "" && *token == "" {
+		host, _, err := net.SplitHostPort(*httpAddr)
+		if err != nil {
+			return err
+		}
+		if !isLoopback(host) {
+			return fmt.Errorf("serving on %s, which is not a loopback address, requires -token", *httpAddr)
+		}
+	}
+
+	s, err := session.New(cwd)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.Init(); err != nil {
+		return err
+	}
+	ctx = s.With(ctx)
+	cfg, err := s.LoadConfig()
+	if err != nil {
+		return err
+	}
+	var defs []tools.ToolDefinition
+	for _, mgr := range tools.NewBuiltinManagers(cwd, cfg) {
+		defer mgr.Close()
+		dfs, err := mgr.ToolDefs(ctx)
+		if err != nil {
+			return err
+		}
+		defs = append(defs, dfs...)
+	}
+
+	if *httpAddr == "" {
+		// The confirmations are asked to the client, since the terminal
+		// is used by the protocol.
+		server, err := tools.NewMCPServer(s, defs, true)
+		if err != nil {
+			return err
+		}
+		ss, err := server.Connect(ctx, &mcp.StdioTransport{}, nil)
+		if err != nil {
+			return err
+		}
+		// The messages of the tools go to the standard error, so that
+		// they don't break the protocol.
+		os.Stdout = os.Stderr
+		return ss.Wait()
+	}
+
+	// The confirmations are asked on the terminal running the server.
+	server, err := tools.NewMCPServer(s, defs, false)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	httpServer := &http.Server{
+		Addr: *httpAddr,
+		Handler: &guardHandler{
+			next:  mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil),
+			token: *token,
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+	log.Printf("Serving the tools on http://%s", *httpAddr)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// isLoopback returns true if the host, a name or an IP address, refers to
+// the local machine.
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// guardHandler rejects the requests not from the local clients, so that the
+// tools, which can run commands, aren't exposed to the other machines or to
+// the web pages in the browser (e.g. by DNS rebinding). When the token is
+// set, the requests with the token are allowed from anywhere instead.
+type guardHandler struct {
+	next  http.Handler
+	token string
+}
+
+func (h *guardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !isLoopback(u.Hostname()) {
+			http.Error(w, "the origin is not allowed", http.StatusForbidden)
+			return
+		}
+	}
+	if h.token != "" {
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if !isLoopback(strings.Trim(host, "[]")) {
+			http.Error(w, "the host is not allowed", http.StatusForbidden)
+			return
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
@@ -13,6 +13,8 @@ import (
 type Agent interface {
 	// send messages and returns the stream of the response.
 	SendMessageStream(ctx context.Context, messages []parts.Part) iter.Seq2[*parts.Part, error]
+	// SetTools replaces the tools of the agent, keeping the conversation.
+	SetTools(ctx context.Context, toolDefs []tools.ToolDefinition) error
 }
 
 // Factory creates a new agent.
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/jmuk/sylvan/pkg/tools"
+)
+
+// The length of the hash suffix added to the shortened names.
+const nameHashLength = 8
+
+// NameRule is the constraint of the tool names of a backend.
+type NameRule struct {
+	// MaxLength is the max length of the names.
+	MaxLength int
+	// Valid returns true if the character is allowed at the index.
+	Valid func(i int, r rune) bool
+}
+
+func (rule NameRule) valid(name string) bool {
+	if name == "" || len(name) > rule.MaxLength {
+		return false
+	}
+	for i, r := range name {
+		if !rule.Valid(i, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitize replaces the invalid characters with '_'. '_' is assumed to
+// be valid anywhere.
+func (rule NameRule) sanitize(name string) string {
+	b := &strings.Builder{}
+	for i, r := range name {
+		if rule.Valid(i, r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// shorten truncates the name to fit the max length with the hash of the
+// original name, to keep it unique.
+func (rule NameRule) shorten(sanitized, original string) string {
+	sum := sha256.Sum256([]byte(original))
+	suffix := "_" + hex.EncodeToString(sum[:])[:nameHashLength]
+	if keep := rule.MaxLength - len(suffix); len(sanitized) > keep {
+		sanitized = sanitized[:keep]
+	}
+	return sanitized + suffix
+}
+
+// NameMapper translates the names of the tools to the ones valid for a
+// backend, and back.
+type NameMapper struct {
+	toBackend   map[string]string
+	fromBackend map[string]string
+}
+
+// NewNameMapper creates a NameMapper for the tools. The valid names are
+// kept as they are, and the others are sanitized and shortened.
+func NewNameMapper(defs []tools.ToolDefinition, rule NameRule) (*NameMapper, error) {
+	m := &NameMapper{
+		toBackend:   map[string]string{},
+		fromBackend: map[string]string{},
+	}
+	var invalid []string
+	for _, d := range defs {
+		if rule.valid(d.Name()) {
+			m.toBackend[d.Name()] = d.Name()
+			m.fromBackend[d.Name()] = d.Name()
+		} else {
+			invalid = append(invalid, d.Name())
+		}
+	}
+	for _, name := range invalid {
+		mapped := rule.sanitize(name)
+		if _, ok := m.fromBackend[mapped]; ok || len(mapped) > rule.MaxLength {
+			mapped = rule.shorten(mapped, name)
+		}
+		if other, ok := m.fromBackend[mapped]; ok {
+			return nil, fmt.Errorf("tool names %s and %s conflict as %s", name, other, mapped)
+		}
+		m.toBackend[name] = mapped
+		m.fromBackend[mapped] = name
+	}
+	return m, nil
+}
+
+// ToBackend returns the name for the backend.
+func (m *NameMapper) ToBackend(name string) string {
+	if mapped, ok := m.toBackend[name]; ok {
+		return mapped
+	}
+	return name
+}
+
+// FromBackend returns the original name of the tool from the name used
+// in the backend.
+func (m *NameMapper) FromBackend(name string) string {
+	if original, ok := m.fromBackend[name]; ok {
+		return original
+	}
+	return name
+}
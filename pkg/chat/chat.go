@@ -7,14 +7,16 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"os/signal"
 	"strings"
-	"time"
 	"unicode"
 
 	"github.com/chzyer/readline"
 	"github.com/jmuk/sylvan/pkg/chat/agent"
 	"github.com/jmuk/sylvan/pkg/chat/parts"
 	"github.com/jmuk/sylvan/pkg/config"
+	"github.com/jmuk/sylvan/pkg/git"
+	"github.com/jmuk/sylvan/pkg/hooks"
 	"github.com/jmuk/sylvan/pkg/session"
 	"github.com/jmuk/sylvan/pkg/tools"
 	"github.com/manifoldco/promptui"
@@ -26,6 +28,8 @@ type chatSession struct {
 	ag     agent.Agent
 	mgrs   []tools.Manager
 	runner *tools.ToolRunner
+	repo   *git.Repo
+	hooks  *hooks.Runner
 }
 
 func (cs *chatSession) maybeInit(ctx context.Context, cwd string) error {
@@ -33,24 +37,70 @@ func (cs *chatSession) maybeInit(ctx context.Context, cwd string) error {
 		return nil
 	}
 	ctx = cs.With(ctx)
+	if err := cs.s.Init(); err != nil {
+		return err
+	}
 	var err error
 	cs.cfg, err = cs.s.LoadConfig()
 	if err != nil {
 		return err
 	}
+	if err := cs.setupGit(ctx, cwd); err != nil {
+		return err
+	}
+	hookLogger, err := cs.s.GetLogger("hooks")
+	if err != nil {
+		return err
+	}
+	cs.hooks, err = hooks.New(cs.cfg.Hooks, cwd, cs.cfg.Exec.ShellPath(), hookLogger)
+	if err != nil {
+		return err
+	}
+	// The managers of the previous init, e.g. before switching the
+	// backend, are replaced.
+	for _, mgr := range cs.mgrs {
+		if err := mgr.Close(); err != nil {
+			fmt.Printf("Failed to close the tools: %v\n", err)
+		}
+	}
 	cs.mgrs = tools.NewManagers(cwd, cs.cfg)
+	return cs.setupTools(ctx)
+}
+
+// setupTools collects the tool definitions from the managers, and creates
+// the tool runner and the agent with them. When the agent already exists,
+// its tools are replaced so that the conversation continues. The MCP
+// servers failing to connect are reported and skipped, so that the chat
+// still works. The sampling requests of the MCP servers are sent to the current backend.
+func (cs *chatSession) setupTools(ctx context.Context) error {
 	var toolDefs []tools.ToolDefinition
 	for _, mgr := range cs.mgrs {
+		if mt, ok := mgr.(*tools.MCPTool); ok {
+			mt.SetSampler(cs.sample)
+		}
 		dfs, err := mgr.ToolDefs(ctx)
 		if err != nil {
-			return err
+			mt, ok := mgr.(*tools.MCPTool)
+			if !ok {
+				return err
+			}
+			fmt.Printf("Failed to connect to the MCP server %s: %v\n", mt.Name(), err)
+			continue
+		}
+		if mt, ok := mgr.(*tools.MCPTool); ok {
+			// For the completion of the resources and the prompts.
+			mt.Prefetch()
 		}
 		toolDefs = append(toolDefs, dfs...)
 	}
+	var err error
 	cs.runner, err = tools.NewToolRunner(toolDefs)
 	if err != nil {
 		return err
 	}
+	if cs.ag != nil {
+		return cs.ag.SetTools(ctx, toolDefs)
+	}
 	cs.ag, err = newAgent(ctx, cs.cfg, SystemPrompt, toolDefs)
 	if err != nil {
 		return err
@@ -58,6 +108,31 @@ func (cs *chatSession) maybeInit(ctx context.Context, cwd string) error {
 	return nil
 }
 
+// sample generates the text for the sampling requests of the MCP servers
+// with the current backend, without the session history.
+func (cs *chatSession) sample(ctx context.Context, systemPrompt string, msgs []parts.Part) (string, string, error) {
+	text, err := generateText(ctx, cs.cfg, systemPrompt, msgs)
+	if err != nil {
+		return "", "", err
+	}
+	return text, cs.cfg.ModelName, nil
+}
+
+// refreshTools rebuilds the tools when any MCP servers notified the change
+// of their tools.
+func (cs *chatSession) refreshTools(ctx context.Context) error {
+	changed := false
+	for _, mgr := range cs.mgrs {
+		if mt, ok := mgr.(*tools.MCPTool); ok && mt.ToolsChanged() {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return cs.setupTools(ctx)
+}
+
 func (cs *chatSession) Close() error {
 	var errs error
 	for _, mgr := range cs.mgrs {
@@ -92,22 +167,21 @@ func New(ctx context.Context, cwd string) (*Chat, error) {
 	if err != nil {
 		return nil, err
 	}
-	completer := newCombinedCompleter(root)
-	rl, err := readline.NewEx(&readline.Config{
+	c := &Chat{
+		cs:          &chatSession{s: s},
+		sessionUsed: false,
+		cwd:         cwd,
+		root:        root,
+	}
+	c.rl, err = readline.NewEx(&readline.Config{
 		Prompt:       "> ",
 		HistoryLimit: -1,
-		AutoComplete: completer,
+		AutoComplete: newCombinedCompleter(root, c),
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &Chat{
-		rl:          rl,
-		cs:          &chatSession{s: s},
-		sessionUsed: false,
-		cwd:         cwd,
-		root:        root,
-	}, nil
+	return c, nil
 }
 
 // Close cleans up the states of the chat.
@@ -160,6 +234,31 @@ func (c *Chat) RunLoop(ctx context.Context) error {
 				return err
 			}
 			continue
+		case commandUndo:
+			if err := c.handleUndoCommand(args); err != nil {
+				return err
+			}
+			continue
+		case commandCheckpoints:
+			if err := c.handleCheckpointsCommand(); err != nil {
+				return err
+			}
+			continue
+		case commandDiff:
+			if err := c.handleDiffCommand(ctx, args); err != nil {
+				return err
+			}
+			continue
+		case commandCommit:
+			if err := c.handleCommitCommand(ctx, args); err != nil {
+				return err
+			}
+			continue
+		case commandMCPPrompt:
+			if err := c.handleMCPPromptCommand(ctx, args); err != nil {
+				return err
+			}
+			continue
 		}
 
 		if err := c.cs.maybeInit(ctx, c.cwd); err != nil {
@@ -172,15 +271,30 @@ func (c *Chat) RunLoop(ctx context.Context) error {
 }
 
 // parseInput parses the input text and hopefully find the pattern of
-// file names prefixed by the '@'.
+// file names prefixed by the '@'. The references to the MCP resources in
+// the form of '@server:uri' are returned separately.
 // Note:
 //   - '\@' isn't the pattern for the input.
 //   - '@' within the backquotes (`@foo` or ```@foo```) don't count.
-func (c *Chat) parseInput(input string) ([]string, error) {
+func (c *Chat) parseInput(input string) ([]string, []string, error) {
 	quote := ""
 	atPos := -1
-	var files []string
+	var files, resources []string
 	var cand []byte
+	addCandidate := func() error {
+		candStr := string(cand)
+		if _, _, ok := c.findMCPRef(candStr); ok {
+			resources = append(resources, candStr)
+		} else if fi, err := c.root.Stat(candStr); err != nil {
+			// Safe to ignore not-found error, that turns out not a file.
+			if !os.IsNotExist(err) {
+				return err
+			}
+		} else if fi.Mode().IsRegular() {
+			files = append(files, candStr)
+		}
+		return nil
+	}
 	for i := 0; i < len(input); i++ {
 		ch := input[i]
 		if ch == '\\' && len(quote) <= 1 {
@@ -208,14 +322,8 @@ func (c *Chat) parseInput(input string) ([]string, error) {
 			}
 		} else {
 			if unicode.IsSpace(rune(ch)) {
-				candStr := string(cand)
-				if fi, err := c.root.Stat(candStr); err != nil {
-					// Safe to ignore not-found error, that turns out not a file.
-					if !os.IsNotExist(err) {
-						return nil, err
-					}
-				} else if fi.Mode().IsRegular() {
-					files = append(files, candStr)
+				if err := addCandidate(); err != nil {
+					return nil, nil, err
 				}
 				atPos = -1
 				cand = nil
@@ -225,29 +333,131 @@ func (c *Chat) parseInput(input string) ([]string, error) {
 		}
 	}
 	if atPos >= 0 {
-		candStr := string(cand)
-		if fi, err := c.root.Stat(candStr); err != nil {
-			if !os.IsNotExist(err) {
-				return nil, err
-			}
-		} else if fi.Mode().IsRegular() {
-			files = append(files, candStr)
+		if err := addCandidate(); err != nil {
+			return nil, nil, err
 		}
 	}
-	return files, nil
+	return files, resources, nil
+}
+
+// The max number of the times turn_complete hooks can continue a turn.
+const maxHookContinuations = 5
+
+// The max number of the characters of the prompt and the response sent to
+// generate the title.
+const maxTitleInputLength = 4000
+
+// runHooks runs the hooks for the event with filling the common fields of
+// the payload.
+func (c *Chat) runHooks(ctx context.Context, p *hooks.Payload) (*hooks.Result, error) {
+	if !c.cs.hooks.Has(p.Event) {
+		return &hooks.Result{}, nil
+	}
+	p.SessionID = c.cs.s.ID()
+	p.Cwd = c.cwd
+	return c.cs.hooks.Run(ctx, p)
+}
+
+// callTool runs the tool for the call with the pre_tool_use and
+// post_tool_use hooks.
+func (c *Chat) callTool(ctx context.Context, call *parts.FunctionCall) (*parts.FunctionResponse, error) {
+	fr := &parts.FunctionResponse{ID: call.ID, Name: call.Name}
+	pre, err := c.runHooks(ctx, &hooks.Payload{
+		Event:     config.HookPreToolUse,
+		ToolName:  call.Name,
+		ToolInput: call.Args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pre.Blocked {
+		msg := "the tool call is blocked by a hook"
+		if pre.Feedback != "" {
+			msg += ": " + pre.Feedback
+		}
+		fmt.Printf("The call of %s is blocked by a hook\n", call.Name)
+		fr.Error = errors.New(msg)
+		return fr, nil
+	}
+
+	// Ctrl-C while running a tool interrupts the tool, rather
+	// than the whole process.
+	toolCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	resp, ps, err := c.cs.runner.Run(toolCtx, call.Name, call.Args)
+	stop()
+	if err != nil {
+		var toolErr *tools.ToolError
+		if !errors.As(err, &toolErr) {
+			return nil, err
+		}
+		err = toolErr.Unwrap()
+	}
+	fr.Response = resp
+	fr.Parts = ps
+	fr.Error = err
+
+	post := &hooks.Payload{
+		Event:        config.HookPostToolUse,
+		ToolName:     call.Name,
+		ToolInput:    call.Args,
+		ToolResponse: resp,
+	}
+	if err != nil {
+		post.ToolError = err.Error()
+	}
+	result, err := c.runHooks(ctx, post)
+	if err != nil {
+		return nil, err
+	}
+	if result.Feedback != "" {
+		prefix := "Output of a hook after this tool call"
+		if result.Blocked {
+			prefix = "A hook reported a problem after this tool call"
+		}
+		fr.Parts = append(fr.Parts, &parts.Part{Text: prefix + ":\n" + result.Feedback})
+	}
+	return fr, nil
 }
 
 // HandleMessage handles a input message, sends to an agent, processes the response.
 func (c *Chat) HandleMessage(ctx context.Context, input string) error {
+	return c.handleMessage(ctx, input, nil)
+}
+
+// handleMessage handles the input message with the extra parts attached.
+func (c *Chat) handleMessage(ctx context.Context, input string, extra []parts.Part) error {
 	l, err := c.cs.s.GetLogger("chat")
 	if err != nil {
 		return err
 	}
 
-	files, err := c.parseInput(input)
+	files, resources, err := c.parseInput(input)
 	if err != nil {
 		return err
 	}
+	submitted, err := c.runHooks(ctx, &hooks.Payload{
+		Event:  config.HookUserPromptSubmit,
+		Prompt: input,
+	})
+	if err != nil {
+		return err
+	}
+	if submitted.Blocked {
+		fmt.Println("The message is blocked by a hook.")
+		if submitted.Feedback != "" {
+			fmt.Println(submitted.Feedback)
+		}
+		return nil
+	}
+	if err := c.cs.refreshTools(ctx); err != nil {
+		return err
+	}
+	if err := c.cs.s.StartTurn(); err != nil {
+		return err
+	}
+	if err := c.cs.s.RecordMessage(input, c.cs.cfg.BackendName+"/"+c.cs.cfg.ModelName); err != nil {
+		return err
+	}
 	var msgs []parts.Part
 	if !c.sessionUsed {
 		customInstruction, err := getCustomInstruction(c.cwd, c.cs.cfg.AgentsFile)
@@ -259,6 +469,10 @@ func (c *Chat) HandleMessage(ctx context.Context, input string) error {
 		}
 	}
 	msgs = append(msgs, parts.Part{Text: input})
+	msgs = append(msgs, extra...)
+	if submitted.Feedback != "" {
+		msgs = append(msgs, parts.Part{Text: fmt.Sprintf("Here attaches the output of the hooks for this message: ```%s```", submitted.Feedback)})
+	}
 	for _, file := range files {
 		content, err := c.root.ReadFile(file)
 		if err != nil {
@@ -275,8 +489,14 @@ func (c *Chat) HandleMessage(ctx context.Context, input string) error {
 			})
 		}
 	}
+	msgs = append(msgs, c.readMCPResources(ctx, resources)...)
+	continuations := 0
+	// The texts of the responses in the turn, for the title.
+	reply := &strings.Builder{}
 	for {
 		printed := false
+		// The text of the response for the transcript.
+		text := &strings.Builder{}
 		var nextMsgs []parts.Part
 		l.Debug("Sending", "messages", msgs)
 		for part, err := range c.cs.ag.SendMessageStream(ctx, msgs) {
@@ -287,36 +507,79 @@ func (c *Chat) HandleMessage(ctx context.Context, input string) error {
 			if part.Text != "" {
 				fmt.Fprint(os.Stdout, part.Text)
 				printed = true
+				if !part.Thought {
+					text.WriteString(part.Text)
+				}
 			}
 			if call := part.FunctionCall; call != nil {
-				commandCtx, cancel := context.WithTimeout(ctx, time.Minute)
-				resp, ps, err := c.cs.runner.Run(commandCtx, call.Name, call.Args)
-				cancel()
+				fr, err := c.callTool(ctx, call)
 				if err != nil {
-					var toolErr *tools.ToolError
-					if !errors.As(err, &toolErr) {
-						return err
-					}
-					err = toolErr.Unwrap()
+					return err
 				}
-				nextMsgs = append(nextMsgs, parts.Part{
-					FunctionResponse: &parts.FunctionResponse{
-						ID:       part.FunctionCall.ID,
-						Name:     part.FunctionCall.Name,
-						Response: resp,
-						Parts:    ps,
-						Error:    err,
-					},
-				})
+				nextMsgs = append(nextMsgs, parts.Part{FunctionResponse: fr})
 			}
 		}
 		if printed {
 			fmt.Println()
 		}
-		if len(nextMsgs) == 0 {
+		if err := c.cs.s.AppendTranscript(session.RoleModel, text.String()); err != nil {
+			return err
+		}
+		reply.WriteString(text.String())
+		if len(nextMsgs) > 0 {
+			msgs = nextMsgs
+			continue
+		}
+		if continuations >= maxHookContinuations {
+			break
+		}
+		completed, err := c.runHooks(ctx, &hooks.Payload{
+			Event:     config.HookTurnComplete,
+			Continued: continuations > 0,
+		})
+		if err != nil {
+			return err
+		}
+		if !completed.Blocked {
 			break
 		}
-		msgs = nextMsgs
+		continuations++
+		fmt.Println("Continuing by a hook.")
+		msg := "A hook asks you to continue."
+		if completed.Feedback != "" {
+			msg += "\n" + completed.Feedback
+		}
+		msgs = []parts.Part{{Text: msg}}
+	}
+	if c.cs.s.MessageCount() == 1 {
+		c.generateTitle(ctx, input, reply.String())
 	}
 	return nil
 }
+
+// generateTitle asks the agent to name the session after the first turn.
+// The failures are only logged, since the title derived from the prompt is
+// used instead.
+func (c *Chat) generateTitle(ctx context.Context, prompt, reply string) {
+	l, err := c.cs.s.GetLogger("chat")
+	if err != nil {
+		return
+	}
+	truncate := func(text string) string {
+		if r := []rune(text); len(r) > maxTitleInputLength {
+			return string(r[:maxTitleInputLength])
+		}
+		return text
+	}
+	title, err := generateText(ctx, c.cs.cfg, TitlePrompt, []parts.Part{
+		{Text: fmt.Sprintf("The request: ```%s```", truncate(prompt))},
+		{Text: fmt.Sprintf("The response: ```%s```", truncate(reply))},
+	})
+	if err != nil {
+		l.Warn("Failed to generate the title", "error", err)
+		return
+	}
+	if err := c.cs.s.SetTitle(title); err != nil {
+		l.Warn("Failed to save the title", "error", err)
+	}
+}
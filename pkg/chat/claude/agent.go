@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"net/url"
 
+	"github.com/jmuk/sylvan/pkg/chat/agent"
 	"github.com/jmuk/sylvan/pkg/chat/parts"
 	"github.com/jmuk/sylvan/pkg/session"
 	"github.com/jmuk/sylvan/pkg/tools"
@@ -24,11 +25,20 @@ type Agent struct {
 	config *Config
 
 	tools []tool
+	names *agent.NameMapper
 
 	historyFile string
 	logger      *slog.Logger
 }
 
+// toolNameRule is the constraint of the tool names of Claude API.
+var toolNameRule = agent.NameRule{
+	MaxLength: 64,
+	Valid: func(i int, r rune) bool {
+		return tools.IsAlnum(r) || r == '_' || r == '-'
+	},
+}
+
 // SendMessageStream implements agent.Agent interface.
 func (a *Agent) SendMessageStream(ctx context.Context, messages []parts.Part) iter.Seq2[*parts.Part, error] {
 	return func(yield func(*parts.Part, error) bool) {
@@ -58,40 +68,55 @@ func (a *Agent) SendMessageStream(ctx context.Context, messages []parts.Part) it
 
 // New creates a new Claude agent.
 func New(ctx context.Context, config *Config, modelName string, systemPrompt string, toolDefs []tools.ToolDefinition) (*Agent, error) {
-	agent := &Agent{
+	a := &Agent{
 		modelName:    modelName,
 		systemPrompt: systemPrompt,
 		config:       config,
 		logger:       slog.New(slog.DiscardHandler),
 	}
+	if err := a.SetTools(ctx, toolDefs); err != nil {
+		return nil, err
+	}
 	if s, ok := session.FromContext(ctx); ok {
-		agent.historyFile = s.HistoryFile()
-		if err := agent.loadHistory(); err != nil {
+		a.historyFile = s.HistoryFile()
+		if err := a.loadHistory(); err != nil {
 			return nil, err
 		}
 		var err error
-		agent.logger, err = s.GetLogger("claude")
+		a.logger, err = s.GetLogger("claude")
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	for _, toolDef := range toolDefs {
-		agent.tools = append(agent.tools, tool{
-			Name:        toolDef.Name(),
-			Description: toolDef.Description(),
-			InputSchema: toolDef.RequestSchema(),
-		})
-	}
 	var err error
-	agent.apiKey, err = config.apiKey()
+	a.apiKey, err = config.apiKey()
 	if err != nil {
 		return nil, err
 	}
-	agent.url, err = url.Parse(config.BaseURL)
+	a.url, err = url.Parse(config.BaseURL)
 	if err != nil {
 		return nil, err
 	}
-	agent.url = agent.url.JoinPath("v1", "messages")
-	return agent, nil
+	a.url = a.url.JoinPath("v1", "messages")
+	return a, nil
+}
+
+// SetTools implements agent.Agent interface.
+func (a *Agent) SetTools(ctx context.Context, toolDefs []tools.ToolDefinition) error {
+	names, err := agent.NewNameMapper(toolDefs, toolNameRule)
+	if err != nil {
+		return err
+	}
+	var ts []tool
+	for _, toolDef := range toolDefs {
+		ts = append(ts, tool{
+			Name:        names.ToBackend(toolDef.Name()),
+			Description: toolDef.Description(),
+			InputSchema: toolDef.RequestSchema(),
+		})
+	}
+	a.tools = ts
+	a.names = names
+	return nil
 }
@@ -186,7 +186,7 @@ func (ep *eventProcessor) processContentBlockStop(ev *sse.Event) (*parts.Part, b
 		}
 		part := &parts.Part{FunctionCall: &parts.FunctionCall{
 			ID:   cb.ContentBlock.ID,
-			Name: cb.ContentBlock.Name,
+			Name: ep.agent.names.FromBackend(cb.ContentBlock.Name),
 			Args: cb.ContentBlock.Input,
 		}}
 		return part, true, nil
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/jmuk/sylvan/pkg/chat/agent"
 	"github.com/jmuk/sylvan/pkg/chat/parts"
 )
 
@@ -111,7 +112,7 @@ type message struct {
 	Role parts.Role  `json:"role"`
 }
 
-func (m message) toInput() (inputMessage, error) {
+func (m message) toInput(names *agent.NameMapper) (inputMessage, error) {
 	msg := inputMessage{Role: m.Role}
 	if m.Part.Text != "" {
 		// a text message or a thought.
@@ -130,7 +131,7 @@ func (m message) toInput() (inputMessage, error) {
 		msg.Content = []toolUseContent{
 			{
 				ID:    fc.ID,
-				Name:  fc.Name,
+				Name:  names.ToBackend(fc.Name),
 				Input: fc.Args,
 				Type:  contentTypeToolUse,
 			},
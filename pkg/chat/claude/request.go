@@ -76,7 +76,7 @@ func (a *Agent) buildRequestBody() ([]byte, error) {
 		Tools: a.tools,
 	}
 	for _, hc := range a.history {
-		imsg, err := hc.toInput()
+		imsg, err := hc.toInput(a.names)
 		if err != nil {
 			return nil, err
 		}
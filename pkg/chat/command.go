@@ -15,6 +15,11 @@ const (
 	commandMCP
 	commandModels
 	commandBackends
+	commandUndo
+	commandCheckpoints
+	commandDiff
+	commandCommit
+	commandMCPPrompt
 )
 
 func (c *Chat) parseCommand(line string) (command, []string) {
@@ -38,9 +43,21 @@ func (c *Chat) parseCommand(line string) (command, []string) {
 		return commandModels, words[1:]
 	case "backends":
 		return commandBackends, words[1:]
+	case "undo":
+		return commandUndo, words[1:]
+	case "checkpoints":
+		return commandCheckpoints, words[1:]
+	case "diff":
+		return commandDiff, words[1:]
+	case "commit":
+		return commandCommit, words[1:]
 	case "commands", "help", "list-commands":
 		return commandList, words[1:]
 	default:
+		if strings.Contains(command, ":") {
+			// The prompt of a MCP server, like /server:prompt.
+			return commandMCPPrompt, words
+		}
 		fmt.Printf("Unknown command %s, ignoring...\n", command)
 		return commandNone, nil
 	}
@@ -50,6 +67,14 @@ func (c *Chat) handleListCommand() {
 	fmt.Println(`List of possible commands:
 - list, commands, help, or ?: this command -- show the list of commands.
 - session: choose a new session.
+- session search <text>: find the sessions with the text in the messages.
+- undo: restore the files changed by the last tool call.
+- undo turn: restore the files changed since the last message.
+- checkpoints: choose a checkpoint and restore the files to that point.
+- diff: show the changes since the session started.
+- commit: commit all the changes with a message drafted by the agent.
+- mcp [add|remove|enable|disable|restart|status|tools]: manage the MCP servers; see /mcp help.
+- server:prompt [args...]: send the prompt of the MCP server.
 - q, quit: quit this program.
 	`)
 }
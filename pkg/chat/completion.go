@@ -14,6 +14,7 @@ type completer interface {
 }
 
 type commandCompleter struct {
+	c *Chat
 }
 
 var knownCommands = []string{
@@ -22,6 +23,10 @@ var knownCommands = []string{
 	"mcp",
 	"backends",
 	"models",
+	"undo",
+	"checkpoints",
+	"diff",
+	"commit",
 	"commands",
 	"help",
 	"list-commands",
@@ -59,6 +64,12 @@ func (cc *commandCompleter) complete(prefix string) []string {
 			results = append(results, cmd[len(prefix):])
 		}
 	}
+	// Only the cached prompts are completed, not to block the input.
+	for _, cmd := range cc.c.cachedMCPPromptCommands() {
+		if strings.HasPrefix(cmd, prefix) {
+			results = append(results, cmd[len(prefix):])
+		}
+	}
 	return results
 }
 
@@ -67,6 +78,11 @@ type fileCompleter struct {
 }
 
 func (fc *fileCompleter) triggerChar(line []rune, pos int) int {
+	return atTriggerChar(line, pos)
+}
+
+// atTriggerChar returns the position of '@' for the word at pos.
+func atTriggerChar(line []rune, pos int) int {
 	i := pos - 1
 	for ; i >= 0; i-- {
 		r := line[i]
@@ -108,6 +124,39 @@ func (fc *fileCompleter) complete(prefix string) []string {
 	return results
 }
 
+// resourceCompleter completes the URIs of the MCP resources in the form of
+// '@server:uri'.
+type resourceCompleter struct {
+	c *Chat
+}
+
+func (rc *resourceCompleter) triggerChar(line []rune, pos int) int {
+	i := atTriggerChar(line, pos)
+	if i < 0 {
+		return -1
+	}
+	if _, _, ok := rc.c.findMCPRef(string(line[i+1 : pos])); !ok {
+		return -1
+	}
+	return i
+}
+
+func (rc *resourceCompleter) complete(prefix string) []string {
+	// the prefix should start with '@'
+	mt, uriPrefix, ok := rc.c.findMCPRef(prefix[1:])
+	if !ok {
+		return nil
+	}
+	// Only the cached resources are completed, not to block the input.
+	var results []string
+	for _, r := range mt.CachedResources() {
+		if strings.HasPrefix(r.URI, uriPrefix) {
+			results = append(results, r.URI[len(uriPrefix):])
+		}
+	}
+	return results
+}
+
 type combinedCompleter struct {
 	comps []completer
 }
@@ -128,10 +177,12 @@ func (c *combinedCompleter) Do(line []rune, pos int) (newLine [][]rune, length i
 	return nil, 0
 }
 
-func newCombinedCompleter(root *os.Root) *combinedCompleter {
+func newCombinedCompleter(root *os.Root, c *Chat) *combinedCompleter {
 	return &combinedCompleter{
 		comps: []completer{
-			&commandCompleter{},
+			&commandCompleter{c},
+			// resourceCompleter takes '@server:' before fileCompleter.
+			&resourceCompleter{c},
 			&fileCompleter{root},
 		},
 	}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/jmuk/sylvan/pkg/chat/agent"
@@ -11,7 +12,9 @@ import (
 	"github.com/jmuk/sylvan/pkg/chat/gemini"
 	"github.com/jmuk/sylvan/pkg/chat/openai"
 	"github.com/jmuk/sylvan/pkg/chat/openai/completion"
+	"github.com/jmuk/sylvan/pkg/chat/parts"
 	"github.com/jmuk/sylvan/pkg/config"
+	"github.com/jmuk/sylvan/pkg/session"
 	"github.com/jmuk/sylvan/pkg/tools"
 )
 
@@ -125,3 +128,25 @@ func newAgent(
 	}
 	return cfg.NewAgent(ctx, c.ModelName, systemPrompt, toolDefs)
 }
+
+// generateText asks a new agent without tools to respond to the messages
+// and returns the text of the response. The agent doesn't see nor store
+// the session history, so that auxiliary tasks like drafting a commit
+// message don't pollute the conversation.
+func generateText(ctx context.Context, c *config.Config, systemPrompt string, msgs []parts.Part) (string, error) {
+	ctx = session.WithoutSession(ctx)
+	ag, err := newAgent(ctx, c, systemPrompt, nil)
+	if err != nil {
+		return "", err
+	}
+	result := &strings.Builder{}
+	for part, err := range ag.SendMessageStream(ctx, msgs) {
+		if err != nil {
+			return "", err
+		}
+		if part.Text != "" && !part.Thought {
+			result.WriteString(part.Text)
+		}
+	}
+	return strings.TrimSpace(result.String()), nil
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 
 	"github.com/invopop/jsonschema"
+	"github.com/jmuk/sylvan/pkg/chat/agent"
 	"github.com/jmuk/sylvan/pkg/chat/parts"
 	"github.com/jmuk/sylvan/pkg/session"
 	"github.com/jmuk/sylvan/pkg/tools"
@@ -29,10 +30,24 @@ func toSchema(s *jsonschema.Schema) (*genai.Schema, error) {
 	return decoded, nil
 }
 
+// toolNameRule is the constraint of the function names of Gemini API.
+var toolNameRule = agent.NameRule{
+	MaxLength: 64,
+	Valid: func(i int, r rune) bool {
+		if i == 0 {
+			return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || r == '_'
+		}
+		return tools.IsAlnum(r) || r == '_' || r == '.' || r == ':' || r == '-'
+	},
+}
+
 // Agent is an agent implementation using Gemini.
 type Agent struct {
+	client      *genai.Client
+	config      *genai.GenerateContentConfig
 	chat        *genai.Chat
 	historyFile string
+	names       *agent.NameMapper
 }
 
 func (g *Agent) saveContent(c *genai.Content) error {
@@ -97,7 +112,7 @@ func (g *Agent) SendMessageStream(ctx context.Context, ps []parts.Part) iter.Seq
 			} else if fr := part.FunctionResponse; fr != nil {
 				resp := &genai.FunctionResponse{
 					ID:       fr.ID,
-					Name:     fr.Name,
+					Name:     g.names.ToBackend(fr.Name),
 					Response: map[string]any{},
 				}
 				if sp, ok := fr.Response.(map[string]any); ok && sp != nil {
@@ -154,7 +169,7 @@ func (g *Agent) SendMessageStream(ctx context.Context, ps []parts.Part) iter.Seq
 				if part.FunctionCall != nil {
 					p.FunctionCall = &parts.FunctionCall{
 						ID:   part.FunctionCall.ID,
-						Name: part.FunctionCall.Name,
+						Name: g.names.FromBackend(part.FunctionCall.Name),
 						Args: part.FunctionCall.Args,
 					}
 				}
@@ -217,45 +232,78 @@ func New(
 		historyFile = s.HistoryFile()
 	}
 
+	var history []*genai.Content
+	if historyFile != "" {
+		history, err = loadHistory(historyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	g := &Agent{
+		client: client,
+		config: &genai.GenerateContentConfig{
+			SystemInstruction: genai.NewContentFromText(
+				systemPrompt,
+				genai.RoleUser,
+			),
+			ThinkingConfig: &genai.ThinkingConfig{
+				IncludeThoughts: includeThoughts,
+			},
+		},
+		historyFile: historyFile,
+	}
+	if err := g.setTools(toolDefs); err != nil {
+		return nil, err
+	}
+	g.chat, err = client.Chats.Create(ctx, "gemini-2.5-flash", g.config, history)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// setTools sets the function declarations of the tools to the config.
+func (g *Agent) setTools(toolDefs []tools.ToolDefinition) error {
+	names, err := agent.NewNameMapper(toolDefs, toolNameRule)
+	if err != nil {
+		return err
+	}
 	var funcs []*genai.FunctionDeclaration
 	for _, d := range toolDefs {
 		params, err := toSchema(d.RequestSchema())
 		if err != nil {
-			return nil, fmt.Errorf("failed to encode request schema for %s: %w", d.Name(), err)
+			return fmt.Errorf("failed to encode request schema for %s: %w", d.Name(), err)
 		}
 		resp, err := toSchema(d.ResponseSchema())
 		if err != nil {
-			return nil, fmt.Errorf("failed to encode response schema for %s: %w", d.Name(), err)
+			return fmt.Errorf("failed to encode response schema for %s: %w", d.Name(), err)
 		}
 		funcs = append(funcs, &genai.FunctionDeclaration{
-			Name:        d.Name(),
+			Name:        names.ToBackend(d.Name()),
 			Description: d.Description(),
 			Behavior:    genai.BehaviorBlocking,
 			Parameters:  params,
 			Response:    resp,
 		})
 	}
+	config := *g.config
+	config.Tools = []*genai.Tool{{FunctionDeclarations: funcs}}
+	g.config = &config
+	g.names = names
+	return nil
+}
 
-	var history []*genai.Content
-	if historyFile != "" {
-		history, err = loadHistory(historyFile)
-		if err != nil {
-			return nil, err
-		}
+// SetTools implements agent.Agent interface. The chat is recreated with
+// the new tools and the history so far.
+func (g *Agent) SetTools(ctx context.Context, toolDefs []tools.ToolDefinition) error {
+	if err := g.setTools(toolDefs); err != nil {
+		return err
 	}
-
-	chat, err := client.Chats.Create(ctx, "gemini-2.5-flash", &genai.GenerateContentConfig{
-		SystemInstruction: genai.NewContentFromText(
-			systemPrompt,
-			genai.RoleUser,
-		),
-		Tools: []*genai.Tool{{FunctionDeclarations: funcs}},
-		ThinkingConfig: &genai.ThinkingConfig{
-			IncludeThoughts: includeThoughts,
-		},
-	}, history)
+	chat, err := g.client.Chats.Create(ctx, "gemini-2.5-flash", g.config, g.chat.History(false))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &Agent{chat: chat, historyFile: historyFile}, nil
+	g.chat = chat
+	return nil
 }
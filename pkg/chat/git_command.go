@@ -0,0 +1,175 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jmuk/sylvan/pkg/chat/parts"
+	"github.com/jmuk/sylvan/pkg/git"
+	"github.com/jmuk/sylvan/pkg/tools"
+	"github.com/manifoldco/promptui"
+)
+
+// The max size of the diff sent to the agent to draft a commit message.
+const maxCommitDiffSize = 64 * 1024
+
+func (cs *chatSession) setupGit(ctx context.Context, cwd string) error {
+	if cs.repo == nil {
+		repo, err := git.Open(ctx, cwd)
+		if errors.Is(err, git.ErrNotRepository) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		cs.repo = repo
+	}
+	if cs.s.GitBase() == "" {
+		base, err := cs.repo.Snapshot(ctx)
+		if err != nil {
+			return err
+		}
+		untracked, err := cs.repo.Untracked(ctx)
+		if err != nil {
+			return err
+		}
+		if err := cs.s.SetGitBase(base, untracked); err != nil {
+			return err
+		}
+	}
+	if !cs.cfg.Git.AutoBranch || cs.s.ID() == "" {
+		return nil
+	}
+	branch := cs.cfg.Git.WorkBranch(cs.s.ID())
+	current, err := cs.repo.CurrentBranch(ctx)
+	if err != nil {
+		return err
+	}
+	if current == branch {
+		return nil
+	}
+	if err := cs.repo.SwitchBranch(ctx, branch); err != nil {
+		// Not fatal; the user can keep working on the current branch.
+		fmt.Println("Failed to switch to the work branch:", err)
+		return nil
+	}
+	fmt.Println("Switched to the work branch", branch)
+	return nil
+}
+
+func (c *Chat) getRepo(ctx context.Context) (*git.Repo, error) {
+	if err := c.cs.maybeInit(ctx, c.cwd); err != nil {
+		return nil, err
+	}
+	if c.cs.repo == nil {
+		fmt.Println("Not in a git repository")
+	}
+	return c.cs.repo, nil
+}
+
+func (c *Chat) handleDiffCommand(ctx context.Context, args []string) error {
+	repo, err := c.getRepo(ctx)
+	if err != nil || repo == nil {
+		return err
+	}
+	diff, err := repo.Diff(ctx, c.cs.s.GitBase(), args...)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	untracked, err := repo.Untracked(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	// The files which were already untracked aren't the changes in the
+	// session.
+	untracked = slices.DeleteFunc(untracked, func(f string) bool {
+		return slices.Contains(c.cs.s.GitUntracked(), f)
+	})
+	if diff == "" && len(untracked) == 0 {
+		fmt.Println("No changes since the session started")
+		return nil
+	}
+	fmt.Print(diff)
+	for _, f := range untracked {
+		fmt.Println("Untracked:", f)
+	}
+	return nil
+}
+
+func (c *Chat) handleCommitCommand(ctx context.Context, args []string) error {
+	repo, err := c.getRepo(ctx)
+	if err != nil || repo == nil {
+		return err
+	}
+	head, err := repo.Head(ctx)
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		head = git.EmptyTree
+	}
+	diff, err := repo.Diff(ctx, head)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	untracked, err := repo.Untracked(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	if diff == "" && len(untracked) == 0 {
+		fmt.Println("Nothing to commit")
+		return nil
+	}
+	if len(diff) > maxCommitDiffSize {
+		diff = diff[:maxCommitDiffSize] + "\n... (truncated)\n"
+	}
+	for _, f := range untracked {
+		diff += fmt.Sprintf("new file: %s\n", f)
+	}
+
+	fmt.Println("Drafting the commit message...")
+	message, err := generateText(ctx, c.cs.cfg, CommitMessagePrompt, []parts.Part{{Text: diff}})
+	if err != nil {
+		return err
+	}
+	for {
+		fmt.Printf("---\n%s\n---\n", message)
+		sel := promptui.Select{
+			Label: "Commit with this message",
+			Items: []string{"Yes", "No", "Edit the message"},
+		}
+		idx, _, err := sel.Run()
+		if err != nil {
+			if err == promptui.ErrInterrupt {
+				return nil
+			}
+			return err
+		}
+		if idx == 1 {
+			return nil
+		}
+		if idx == 0 {
+			break
+		}
+		l, err := c.cs.s.GetLogger("chat")
+		if err != nil {
+			return err
+		}
+		message, err = tools.EditText(l, "COMMIT_EDITMSG", message)
+		if err != nil {
+			return err
+		}
+	}
+	commit, err := repo.CommitAll(ctx, message)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	fmt.Println("Committed", commit)
+	return nil
+}
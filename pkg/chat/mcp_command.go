@@ -2,23 +2,285 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmuk/sylvan/pkg/config"
+	"github.com/jmuk/sylvan/pkg/tools"
 )
 
+// The timeout to check the status of a MCP server.
+const mcpStatusTimeout = 10 * time.Second
+
+const mcpUsage = `Usage of mcp:
+- mcp: show the list of the MCP servers.
+- mcp add [--user] <name> <command...|url>: add a MCP server to the project config, or to the user config with --user.
+- mcp remove <name>: remove the MCP server from the config.
+- mcp enable <name>: connect to the disabled MCP server.
+- mcp disable <name>: keep the MCP server in the config without connecting to it.
+- mcp restart <name>: reconnect to the MCP server.
+- mcp status [name]: show the connection status of the MCP servers.
+- mcp tools <name>: show the tools of the MCP server with their schemas.`
+
+// errMCPNotFound is returned when the MCP server isn't in the config file.
+var errMCPNotFound = errors.New("MCP server not found")
+
 func (c *Chat) handleMCPCommand(ctx context.Context, args []string) error {
+	if err := c.cs.maybeInit(ctx, c.cwd); err != nil {
+		return err
+	}
 	if len(args) == 0 {
 		// show the list of MCP tools.
-		if c.cs.cfg == nil {
-			if err := c.cs.maybeInit(ctx, c.cwd); err != nil {
-				return err
-			}
-		}
 		for _, mcpc := range c.cs.cfg.MCP {
 			fmt.Println(mcpc.String())
 		}
 		return nil
 	}
 
-	// TODO: use an LLM to turn the user input to the correct intent.
+	var err error
+	switch sub, rest := args[0], args[1:]; sub {
+	case "add":
+		err = c.addMCPServer(ctx, rest)
+	case "remove", "enable", "disable":
+		if len(rest) != 1 {
+			fmt.Println(mcpUsage)
+			return nil
+		}
+		err = c.editMCPServer(ctx, sub, rest[0])
+	case "restart":
+		if len(rest) != 1 {
+			fmt.Println(mcpUsage)
+			return nil
+		}
+		err = c.restartMCPServer(ctx, rest[0])
+	case "status":
+		c.showMCPStatus(ctx, rest)
+	case "tools":
+		if len(rest) != 1 {
+			fmt.Println(mcpUsage)
+			return nil
+		}
+		err = c.showMCPTools(ctx, rest[0])
+	default:
+		fmt.Println(mcpUsage)
+		return nil
+	}
+	if err != nil {
+		// The failures of the MCP servers or the configs shouldn't stop
+		// the chat.
+		fmt.Println(err)
+	}
+	return nil
+}
+
+// findMCPTool returns the connected MCP server of the name.
+func (c *Chat) findMCPTool(name string) (*tools.MCPTool, bool) {
+	for _, mt := range c.mcpTools() {
+		if mt.Name() == name {
+			return mt, true
+		}
+	}
+	return nil, false
+}
+
+func indexMCPConfig(mcps []config.MCPConfig, name string) int {
+	return slices.IndexFunc(mcps, func(mcpc config.MCPConfig) bool {
+		return mcpc.Name == name
+	})
+}
+
+func (c *Chat) addMCPServer(ctx context.Context, args []string) error {
+	userConfig := false
+	if len(args) > 0 && args[0] == "--user" {
+		userConfig = true
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Println(mcpUsage)
+		return nil
+	}
+	name := args[0]
+	if indexMCPConfig(c.cs.cfg.MCP, name) >= 0 {
+		return fmt.Errorf("MCP server %s already exists", name)
+	}
+	mcpc := config.MCPConfig{Name: name}
+	if len(args) == 2 && (strings.HasPrefix(args[1], "http://") || strings.HasPrefix(args[1], "https://")) {
+		mcpc.Endpoint = args[1]
+	} else {
+		mcpc.Command = args[1:]
+	}
+
+	userFile, err := config.DefaultConfigFile()
+	if err != nil {
+		return err
+	}
+	configFile := userFile
+	if !userConfig {
+		if configFile, err = c.cs.s.ProjectConfigFile(); err != nil {
+			return err
+		}
+	}
+	err = config.EditConfig(configFile, func(cfg *config.Config) (*config.Config, error) {
+		if !userConfig && cfg.MCP == nil {
+			// The MCP servers in the project config replace the ones
+			// in the user config, so they are copied first.
+			userCfg, err := config.LoadConfigFile(userFile)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			if userCfg != nil {
+				cfg.MCP = slices.Clone(userCfg.MCP)
+			}
+		}
+		cfg.MCP = append(cfg.MCP, mcpc)
+		return cfg, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.cs.reloadMCP(ctx, c.cwd); err != nil {
+		return err
+	}
+	if indexMCPConfig(c.cs.cfg.MCP, name) < 0 {
+		fmt.Printf("Added %s to the user config, but it's overridden by the MCP servers in the project config.\n", name)
+		return nil
+	}
+	fmt.Printf("Added %s to %s\n", name, configFile)
+	return nil
+}
+
+// editMCPServer removes, enables, or disables the MCP server in the project
+// config if it's defined there, or in the user config otherwise.
+func (c *Chat) editMCPServer(ctx context.Context, op string, name string) error {
+	projectFile, err := c.cs.s.ProjectConfigFile()
+	if err != nil {
+		return err
+	}
+	userFile, err := config.DefaultConfigFile()
+	if err != nil {
+		return err
+	}
+	edited := false
+	for _, configFile := range []string{projectFile, userFile} {
+		err := config.EditConfig(configFile, func(cfg *config.Config) (*config.Config, error) {
+			i := indexMCPConfig(cfg.MCP, name)
+			if i < 0 {
+				return nil, errMCPNotFound
+			}
+			switch op {
+			case "remove":
+				cfg.MCP = slices.Delete(cfg.MCP, i, i+1)
+			case "enable":
+				cfg.MCP[i].Disabled = false
+			case "disable":
+				cfg.MCP[i].Disabled = true
+			}
+			return cfg, nil
+		})
+		if errors.Is(err, errMCPNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		edited = true
+		break
+	}
+	if !edited {
+		return fmt.Errorf("MCP server %s is not found in the config files", name)
+	}
+	return c.cs.reloadMCP(ctx, c.cwd)
+}
+
+func (c *Chat) restartMCPServer(ctx context.Context, name string) error {
+	mt, ok := c.findMCPTool(name)
+	if !ok {
+		return fmt.Errorf("MCP server %s is not connected", name)
+	}
+	if err := mt.Close(); err != nil {
+		fmt.Printf("Failed to close %s: %v\n", name, err)
+	}
+	// Rebuilding the tools reconnects to the server.
+	return c.cs.setupTools(ctx)
+}
+
+func (c *Chat) showMCPStatus(ctx context.Context, names []string) {
+	for _, mcpc := range c.cs.cfg.MCP {
+		if len(names) > 0 && !slices.Contains(names, mcpc.Name) {
+			continue
+		}
+		status := "connected"
+		if mcpc.Disabled {
+			status = "disabled"
+		} else if mt, ok := c.findMCPTool(mcpc.Name); !ok {
+			status = "invalid config: neither command nor endpoint is specified"
+		} else {
+			pingCtx, cancel := context.WithTimeout(ctx, mcpStatusTimeout)
+			if err := mt.Ping(pingCtx); err != nil {
+				status = fmt.Sprintf("error: %v", err)
+			}
+			cancel()
+		}
+		fmt.Printf("%s: %s\n", mcpc.String(), status)
+	}
+}
+
+func (c *Chat) showMCPTools(ctx context.Context, name string) error {
+	mt, ok := c.findMCPTool(name)
+	if !ok {
+		return fmt.Errorf("MCP server %s is not connected", name)
+	}
+	defs, err := mt.ToolDefs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, def := range defs {
+		fmt.Printf("%s: %s\n", def.Name(), def.Description())
+		schema, err := json.MarshalIndent(def.RequestSchema(), "  ", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %s\n", schema)
+	}
 	return nil
 }
+
+// reloadMCP reloads the config and reconnects to the MCP servers whose
+// configs are changed, then rebuilds the tools of the agent.
+func (cs *chatSession) reloadMCP(ctx context.Context, cwd string) error {
+	cfg, err := cs.s.LoadConfig()
+	if err != nil {
+		return err
+	}
+	cs.cfg = cfg
+	current := map[string]*tools.MCPTool{}
+	var builtins []tools.Manager
+	for _, mgr := range cs.mgrs {
+		if mt, ok := mgr.(*tools.MCPTool); ok {
+			current[mt.Name()] = mt
+		} else {
+			builtins = append(builtins, mgr)
+		}
+	}
+	var mgrs []tools.Manager
+	for _, mt := range tools.NewMCPs(cwd, cfg) {
+		if old, ok := current[mt.Name()]; ok && reflect.DeepEqual(old.Config(), mt.Config()) {
+			mt = old
+			delete(current, mt.Name())
+		}
+		mgrs = append(mgrs, mt)
+	}
+	for name, mt := range current {
+		if err := mt.Close(); err != nil {
+			fmt.Printf("Failed to close %s: %v\n", name, err)
+		}
+	}
+	cs.mgrs = append(mgrs, builtins...)
+	return cs.setupTools(ctx)
+}
@@ -0,0 +1,197 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmuk/sylvan/pkg/chat/parts"
+	"github.com/jmuk/sylvan/pkg/tools"
+	"github.com/manifoldco/promptui"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// mcpTools returns the MCP servers of the current session.
+func (c *Chat) mcpTools() []*tools.MCPTool {
+	var results []*tools.MCPTool
+	for _, mgr := range c.cs.mgrs {
+		if mt, ok := mgr.(*tools.MCPTool); ok {
+			results = append(results, mt)
+		}
+	}
+	return results
+}
+
+// findMCPRef splits the reference like "server:name" into the MCP server
+// and the name. The server names can contain ':', so the longest matching
+// server name is used.
+func (c *Chat) findMCPRef(ref string) (*tools.MCPTool, string, bool) {
+	var found *tools.MCPTool
+	for _, mt := range c.mcpTools() {
+		if !strings.HasPrefix(ref, mt.Name()+":") {
+			continue
+		}
+		if found == nil || len(mt.Name()) > len(found.Name()) {
+			found = mt
+		}
+	}
+	if found == nil {
+		return nil, "", false
+	}
+	return found, ref[len(found.Name())+1:], true
+}
+
+// cachedMCPPromptCommands returns the cached prompts of the MCP servers
+// in the form of "server:prompt".
+func (c *Chat) cachedMCPPromptCommands() []string {
+	var results []string
+	for _, mt := range c.mcpTools() {
+		for _, p := range mt.CachedPrompts() {
+			results = append(results, mt.Name()+":"+p.Name)
+		}
+	}
+	return results
+}
+
+// promptArguments fills the arguments of the prompt from the words.
+// "name=value" sets the argument by the name, and the other words fill
+// the arguments in order; the last argument takes the rest of the words.
+// The missing required arguments are asked to the user.
+func promptArguments(p *mcp.Prompt, words []string) (map[string]string, error) {
+	args := map[string]string{}
+	known := map[string]bool{}
+	for _, arg := range p.Arguments {
+		known[arg.Name] = true
+	}
+	var positional []string
+	for _, w := range words {
+		if k, v, ok := strings.Cut(w, "="); ok && known[k] {
+			args[k] = v
+		} else {
+			positional = append(positional, w)
+		}
+	}
+	var rest []*mcp.PromptArgument
+	for _, arg := range p.Arguments {
+		if _, ok := args[arg.Name]; !ok {
+			rest = append(rest, arg)
+		}
+	}
+	for i, arg := range rest {
+		if len(positional) == 0 {
+			break
+		}
+		if i == len(rest)-1 {
+			args[arg.Name] = strings.Join(positional, " ")
+			positional = nil
+		} else {
+			args[arg.Name] = positional[0]
+			positional = positional[1:]
+		}
+	}
+	if len(positional) > 0 {
+		return nil, fmt.Errorf("too many arguments: %s", strings.Join(positional, " "))
+	}
+	for _, arg := range p.Arguments {
+		if _, ok := args[arg.Name]; ok || !arg.Required {
+			continue
+		}
+		label := arg.Name
+		if arg.Description != "" {
+			label += " (" + arg.Description + ")"
+		}
+		prompt := promptui.Prompt{Label: label}
+		v, err := prompt.Run()
+		if err != nil {
+			return nil, err
+		}
+		args[arg.Name] = v
+	}
+	return args, nil
+}
+
+// handleMCPPromptCommand runs the MCP prompt for the command like
+// "/server:prompt args...", and sends its messages to the agent.
+func (c *Chat) handleMCPPromptCommand(ctx context.Context, words []string) error {
+	if err := c.cs.maybeInit(ctx, c.cwd); err != nil {
+		return err
+	}
+	mt, name, ok := c.findMCPRef(words[0])
+	if !ok {
+		fmt.Printf("Unknown command %s, ignoring...\n", words[0])
+		return nil
+	}
+	prompts, err := mt.Prompts(ctx)
+	if err != nil {
+		fmt.Printf("Failed to list the prompts of %s: %v\n", mt.Name(), err)
+		return nil
+	}
+	var prompt *mcp.Prompt
+	for _, p := range prompts {
+		if p.Name == name {
+			prompt = p
+			break
+		}
+	}
+	if prompt == nil {
+		fmt.Printf("Unknown prompt %s of %s, ignoring...\n", name, mt.Name())
+		return nil
+	}
+	args, err := promptArguments(prompt, words[1:])
+	if err != nil {
+		if err == promptui.ErrInterrupt {
+			return nil
+		}
+		fmt.Println(err)
+		return nil
+	}
+	text, ps, err := mt.GetPrompt(ctx, prompt.Name, args)
+	if err != nil {
+		fmt.Printf("Failed to get the prompt: %v\n", err)
+		return nil
+	}
+	extra := make([]parts.Part, 0, len(ps))
+	for _, p := range ps {
+		extra = append(extra, *p)
+	}
+	return c.handleMessage(ctx, text, extra)
+}
+
+// readMCPResources reads the resources referenced in the input, and the
+// subscribed resources which are updated since the last message.
+func (c *Chat) readMCPResources(ctx context.Context, refs []string) []parts.Part {
+	var results []parts.Part
+	read := map[string]bool{}
+	for _, ref := range refs {
+		mt, uri, ok := c.findMCPRef(ref)
+		if !ok {
+			continue
+		}
+		read[ref] = true
+		ps, err := mt.ReadResource(ctx, uri)
+		if err != nil {
+			fmt.Printf("Failed to read %s: %v\n", ref, err)
+			continue
+		}
+		for _, p := range ps {
+			results = append(results, *p)
+		}
+	}
+	for _, mt := range c.mcpTools() {
+		for _, uri := range mt.UpdatedResources() {
+			if read[mt.Name()+":"+uri] {
+				continue
+			}
+			ps, err := mt.ReadResource(ctx, uri)
+			if err != nil {
+				fmt.Printf("Failed to read the updated resource %s:%s: %v\n", mt.Name(), uri, err)
+				continue
+			}
+			results = append(results, parts.Part{Text: fmt.Sprintf("The resource %s:%s has been updated.", mt.Name(), uri)})
+			for _, p := range ps {
+				results = append(results, *p)
+			}
+		}
+	}
+	return results
+}
@@ -7,8 +7,10 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/jmuk/sylvan/pkg/chat/agent"
 	"github.com/jmuk/sylvan/pkg/chat/parts"
 	"github.com/jmuk/sylvan/pkg/session"
+	"github.com/jmuk/sylvan/pkg/tools"
 	"github.com/openai/openai-go/v3/packages/param"
 	"github.com/openai/openai-go/v3/responses"
 	"github.com/openai/openai-go/v3/shared"
@@ -22,6 +24,7 @@ type Agent struct {
 	model        shared.ResponsesModel
 	systemPrompt string
 	tools        []responses.ToolUnionParam
+	names        *agent.NameMapper
 
 	previousResponseID param.Opt[string]
 }
@@ -90,6 +93,7 @@ func (a *Agent) SendMessageStream(ctx context.Context, ps []parts.Part) iter.Seq
 
 		proc := &outputProcessor{
 			logger: logger,
+			names:  a.names,
 		}
 
 		proc.processStream(st, yield)
@@ -107,3 +111,22 @@ func (a *Agent) SendMessageStream(ctx context.Context, ps []parts.Part) iter.Seq
 		}
 	}
 }
+
+// SetTools implements agent.Agent interface.
+func (a *Agent) SetTools(ctx context.Context, toolDefs []tools.ToolDefinition) error {
+	names, err := agent.NewNameMapper(toolDefs, ToolNameRule)
+	if err != nil {
+		return err
+	}
+	var toolParams []responses.ToolUnionParam
+	for _, tdef := range toolDefs {
+		toolParam, err := convertToolDef(tdef, names)
+		if err != nil {
+			return err
+		}
+		toolParams = append(toolParams, toolParam)
+	}
+	a.tools = toolParams
+	a.names = names
+	return nil
+}
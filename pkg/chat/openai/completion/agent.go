@@ -6,8 +6,11 @@ import (
 	"iter"
 	"log/slog"
 
+	"github.com/jmuk/sylvan/pkg/chat/agent"
+	sylvanopenai "github.com/jmuk/sylvan/pkg/chat/openai"
 	"github.com/jmuk/sylvan/pkg/chat/parts"
 	"github.com/jmuk/sylvan/pkg/session"
+	"github.com/jmuk/sylvan/pkg/tools"
 	"github.com/openai/openai-go/v3"
 )
 
@@ -18,6 +21,7 @@ type Agent struct {
 	systemPrompt string
 
 	tools []openai.ChatCompletionToolUnionParam
+	names *agent.NameMapper
 
 	history     []openai.ChatCompletionMessageParamUnion
 	historyFile string
@@ -61,6 +65,7 @@ func (a *Agent) SendMessageStream(ctx context.Context, ps []parts.Part) iter.Seq
 
 		proc := &outputProcessor{
 			logger: logger,
+			names:  a.names,
 		}
 
 		proc.processStream(st, yield)
@@ -76,3 +81,22 @@ func (a *Agent) SendMessageStream(ctx context.Context, ps []parts.Part) iter.Seq
 		}
 	}
 }
+
+// SetTools implements agent.Agent interface.
+func (a *Agent) SetTools(ctx context.Context, toolDefs []tools.ToolDefinition) error {
+	names, err := agent.NewNameMapper(toolDefs, sylvanopenai.ToolNameRule)
+	if err != nil {
+		return err
+	}
+	var toolParams []openai.ChatCompletionToolUnionParam
+	for _, tdef := range toolDefs {
+		toolParam, err := convertToolDef(tdef, names)
+		if err != nil {
+			return err
+		}
+		toolParams = append(toolParams, toolParam)
+	}
+	a.tools = toolParams
+	a.names = names
+	return nil
+}
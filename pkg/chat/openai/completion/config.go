@@ -16,7 +16,7 @@ import (
 // Config is the configuration.
 type Config sylvanopenai.Config
 
-func convertToolDef(d tools.ToolDefinition) (openai.ChatCompletionToolUnionParam, error) {
+func convertToolDef(d tools.ToolDefinition, names *agent.NameMapper) (openai.ChatCompletionToolUnionParam, error) {
 	rsch := d.RequestSchema()
 	encoded, err := json.Marshal(rsch)
 	if err != nil {
@@ -29,7 +29,7 @@ func convertToolDef(d tools.ToolDefinition) (openai.ChatCompletionToolUnionParam
 	return openai.ChatCompletionToolUnionParam{
 		OfFunction: &openai.ChatCompletionFunctionToolParam{
 			Function: shared.FunctionDefinitionParam{
-				Name:        d.Name(),
+				Name:        names.ToBackend(d.Name()),
 				Description: param.NewOpt(d.Description()),
 				Parameters:  parameters,
 			},
@@ -68,27 +68,21 @@ func (c *Config) NewAgent(
 		return nil, err
 	}
 
-	var toolParams []openai.ChatCompletionToolUnionParam
-	for _, tdef := range toolDefs {
-		toolParam, err := convertToolDef(tdef)
-		if err != nil {
-			return nil, err
-		}
-		toolParams = append(toolParams, toolParam)
-	}
-
 	var historyFile string
 
-	return &Agent{
+	a := &Agent{
 		client:       openai.NewChatCompletionService(opts...),
 		historyFile:  historyFile,
 		modelName:    modelName,
 		systemPrompt: systemPrompt,
-		tools:        toolParams,
 		history: []openai.ChatCompletionMessageParamUnion{
 			openai.SystemMessage(systemPrompt),
 		},
-	}, nil
+	}
+	if err := a.SetTools(ctx, toolDefs); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
 // Models implements chat.BackendConfig interface.
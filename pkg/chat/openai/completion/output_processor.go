@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log/slog"
 
+	"github.com/jmuk/sylvan/pkg/chat/agent"
 	"github.com/jmuk/sylvan/pkg/chat/parts"
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/packages/ssestream"
@@ -11,6 +12,7 @@ import (
 
 type outputProcessor struct {
 	logger  *slog.Logger
+	names   *agent.NameMapper
 	history []openai.ChatCompletionMessageParamUnion
 }
 
@@ -60,7 +62,7 @@ func (p *outputProcessor) process(ev openai.ChatCompletionChunk) ([]*parts.Part,
 			ps = append(ps, &parts.Part{
 				FunctionCall: &parts.FunctionCall{
 					ID:   tc.ID,
-					Name: tc.Function.Name,
+					Name: p.names.FromBackend(tc.Function.Name),
 					Args: parsed,
 				},
 			})
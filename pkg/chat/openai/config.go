@@ -36,7 +36,15 @@ func (c *Config) Name() string {
 	return c.ConfigName
 }
 
-func convertToolDef(d tools.ToolDefinition) (responses.ToolUnionParam, error) {
+// ToolNameRule is the constraint of the function names of OpenAI API.
+var ToolNameRule = agent.NameRule{
+	MaxLength: 64,
+	Valid: func(i int, r rune) bool {
+		return tools.IsAlnum(r) || r == '_' || r == '-'
+	},
+}
+
+func convertToolDef(d tools.ToolDefinition, names *agent.NameMapper) (responses.ToolUnionParam, error) {
 	rsch := d.RequestSchema()
 	encoded, err := json.Marshal(rsch)
 	if err != nil {
@@ -49,7 +57,7 @@ func convertToolDef(d tools.ToolDefinition) (responses.ToolUnionParam, error) {
 	return responses.ToolUnionParam{
 		OfFunction: &responses.FunctionToolParam{
 			Parameters:  parameters,
-			Name:        d.Name(),
+			Name:        names.ToBackend(d.Name()),
 			Description: param.NewOpt(d.Description()),
 			Type:        "function",
 		},
@@ -98,14 +106,6 @@ func (c *Config) NewAgent(
 	if err != nil {
 		return nil, err
 	}
-	var toolParams []responses.ToolUnionParam
-	for _, tdef := range toolDefs {
-		toolParam, err := convertToolDef(tdef)
-		if err != nil {
-			return nil, err
-		}
-		toolParams = append(toolParams, toolParam)
-	}
 
 	var historyFile string
 	var lastResponseID param.Opt[string]
@@ -118,14 +118,17 @@ func (c *Config) NewAgent(
 		}
 	}
 
-	return &Agent{
+	a := &Agent{
 		client:             responses.NewResponseService(opts...),
 		historyFile:        historyFile,
 		previousResponseID: lastResponseID,
 		model:              modelName,
 		systemPrompt:       systemPrompt,
-		tools:              toolParams,
-	}, nil
+	}
+	if err := a.SetTools(ctx, toolDefs); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
 // Models implements chat.BackendConfig interface.
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/jmuk/sylvan/pkg/chat/agent"
 	"github.com/jmuk/sylvan/pkg/chat/parts"
 	"github.com/openai/openai-go/v3/packages/ssestream"
 	"github.com/openai/openai-go/v3/responses"
@@ -12,6 +13,7 @@ import (
 
 type outputProcessor struct {
 	logger     *slog.Logger
+	names      *agent.NameMapper
 	fc         *parts.FunctionCall
 	param      string
 	responseID string
@@ -36,7 +38,7 @@ func (p *outputProcessor) process(ev responses.ResponseStreamEventUnion) (*parts
 		if variant.Item.Type == "function_call" {
 			call := variant.Item.AsFunctionCall()
 			p.fc = &parts.FunctionCall{
-				Name: call.Name,
+				Name: p.names.FromBackend(call.Name),
 				ID:   call.CallID,
 			}
 			p.param = call.Arguments
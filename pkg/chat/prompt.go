@@ -36,6 +36,23 @@ Then you write the code, and make sure that the tests now _pass_. Note that the
 code must not be modified during this step.
 `
 
+// CommitMessagePrompt is the system prompt to draft a commit message.
+const CommitMessagePrompt = `
+You are a seasoned software engineer writing a git commit message for the
+changes given by the user as a diff.  Write a concise subject line in the
+imperative mood of at most 72 characters, then an empty line, then a short
+body describing what changed and why when it isn't obvious from the subject.
+Reply with the commit message only, without any quotes or code fences.
+`
+
+// TitlePrompt is the system prompt to generate the title of a session.
+const TitlePrompt = `
+You are naming a conversation between a user and a coding agent, given the
+first request of the user and the response of the agent. Reply with a short
+title of at most eight words describing the task, without any quotes or
+trailing punctuation.
+`
+
 func getCustomInstruction(cwd string, customAgentsFile string) (string, error) {
 	for _, agentsFile := range append([]string{customAgentsFile}, "AGENTS.md", "CLAUDE.md", "GEMINI.md") {
 		if agentsFile == "" {
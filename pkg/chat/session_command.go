@@ -3,31 +3,45 @@ package chat
 import (
 	"fmt"
 	"sort"
-	"time"
+	"strings"
 
 	"github.com/jmuk/sylvan/pkg/session"
 	"github.com/manifoldco/promptui"
 )
 
-func (c *Chat) chooseNewSession() (*session.Session, error) {
-	// Choose a new session.
+// The format of the last activity in the session picker.
+const sessionTimeFormat = "2006-01-02 15:04"
+
+// sessionSummary returns the line describing the session in the pickers.
+func sessionSummary(s *session.Session) string {
+	title := s.Title()
+	if title == "" {
+		title = "(no messages)"
+	}
+	id := s.ID()
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	details := []string{fmt.Sprintf("%d messages", s.MessageCount())}
+	if s.Model() != "" {
+		details = append(details, s.Model())
+	}
+	details = append(details, s.LastActivity().Format(sessionTimeFormat))
+	return fmt.Sprintf("[%s] %s (%s)", id, title, strings.Join(details, ", "))
+}
+
+// listSessions returns the sessions of the project, the recently active
+// ones first, including the current one.
+func (c *Chat) listSessions() ([]*session.Session, error) {
 	sessions, err := session.ListSessions(c.cwd)
 	if err != nil {
 		return nil, err
 	}
-	if len(sessions) == 0 {
-		fmt.Println("No sessions found to select")
-		return nil, nil
-	}
-	sort.Slice(sessions, func(i, j int) bool {
-		t1 := sessions[i].Timestamp()
-		t2 := sessions[j].Timestamp()
-		// Newer one comes earlier.
-		return t1.After(t2)
-	})
 	var foundExisting bool
-	for _, s := range sessions {
+	for i, s := range sessions {
 		if s.ID() == c.cs.s.ID() {
+			// The current session may have newer metadata.
+			sessions[i] = c.cs.s
 			foundExisting = true
 			break
 		}
@@ -35,25 +49,33 @@ func (c *Chat) chooseNewSession() (*session.Session, error) {
 	if !foundExisting {
 		sessions = append([]*session.Session{c.cs.s}, sessions...)
 	}
-	items := make([]string, 0, len(sessions))
-	var cursorPos int
+	sort.SliceStable(sessions, func(i, j int) bool {
+		// Newer one comes earlier.
+		return sessions[i].LastActivity().After(sessions[j].LastActivity())
+	})
+	return sessions, nil
+}
+
+// selectSession lets the user choose one of the sessions described by the
+// items. It returns nil when the current session or nothing is chosen.
+func (c *Chat) selectSession(label string, sessions []*session.Session, items []string) (*session.Session, error) {
+	cursorPos := 0
 	for i, s := range sessions {
-		item := fmt.Sprintf("%s at %s", s.ID(), s.Timestamp().Format(time.RFC1123Z))
 		if s.ID() == c.cs.s.ID() {
-			item += " (current session)"
+			items[i] += " (current session)"
 			cursorPos = i
 		}
-		items = append(items, item)
 	}
 	sel := promptui.Select{
-		Label:     "Select the session to switch",
+		Label:     label,
 		Items:     items,
 		CursorPos: cursorPos,
+		Size:      20,
 	}
 	idx, _, err := sel.Run()
 	if err != nil {
 		if err == promptui.ErrInterrupt {
-			return sessions[cursorPos], nil
+			return nil, nil
 		}
 		return nil, err
 	}
@@ -63,6 +85,56 @@ func (c *Chat) chooseNewSession() (*session.Session, error) {
 	return sessions[idx], nil
 }
 
+func (c *Chat) chooseNewSession() (*session.Session, error) {
+	// Choose a new session.
+	sessions, err := c.listSessions()
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) <= 1 {
+		fmt.Println("No sessions found to select")
+		return nil, nil
+	}
+	items := make([]string, 0, len(sessions))
+	for _, s := range sessions {
+		items = append(items, sessionSummary(s))
+	}
+	return c.selectSession("Select the session to switch", sessions, items)
+}
+
+// searchSessions finds the sessions of the project whose messages contain
+// the text, and lets the user choose one of them.
+func (c *Chat) searchSessions(text string) (*session.Session, error) {
+	sessions, err := c.listSessions()
+	if err != nil {
+		return nil, err
+	}
+	var found []*session.Session
+	var items []string
+	for _, s := range sessions {
+		matches, err := s.Search(text)
+		if err != nil {
+			fmt.Printf("Failed to search the session %s: %v\n", s.ID(), err)
+			continue
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		m := matches[0]
+		snippet := m.Snippet
+		if m.Role != "" {
+			snippet = m.Role + ": " + snippet
+		}
+		found = append(found, s)
+		items = append(items, fmt.Sprintf("%s: %s (%d matches)", sessionSummary(s), snippet, len(matches)))
+	}
+	if len(found) == 0 {
+		fmt.Printf("No sessions found with %q\n", text)
+		return nil, nil
+	}
+	return c.selectSession(fmt.Sprintf("Sessions with %q", text), found, items)
+}
+
 func (c *Chat) handleSessionCommands(args []string) (bool, error) {
 	var newSession *session.Session
 	if len(args) == 0 {
@@ -71,6 +143,16 @@ func (c *Chat) handleSessionCommands(args []string) (bool, error) {
 		if err != nil {
 			return false, err
 		}
+	} else if args[0] == "search" {
+		if len(args) < 2 {
+			fmt.Println("Usage: session search <text>")
+			return false, nil
+		}
+		var err error
+		newSession, err = c.searchSessions(strings.Join(args[1:], " "))
+		if err != nil {
+			return false, err
+		}
 	} else {
 		sessionID := args[0]
 		if sessionID == "last" {
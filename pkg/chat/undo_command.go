@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmuk/sylvan/pkg/session"
+	"github.com/manifoldco/promptui"
+)
+
+func (c *Chat) restoreCheckpoint(id int) error {
+	restored, err := c.cs.s.RestoreCheckpoint(c.root, id)
+	for _, p := range restored {
+		fmt.Println("Restored", p)
+	}
+	return err
+}
+
+func (c *Chat) handleUndoCommand(args []string) error {
+	checkpoints, err := c.cs.s.Checkpoints()
+	if err != nil {
+		return err
+	}
+	if len(checkpoints) == 0 {
+		fmt.Println("Nothing to undo")
+		return nil
+	}
+	last := checkpoints[len(checkpoints)-1]
+	if len(args) == 0 {
+		return c.restoreCheckpoint(last.ID)
+	}
+	if args[0] != "turn" {
+		fmt.Printf("Unknown argument %s for undo, ignoring...\n", args[0])
+		return nil
+	}
+	id := last.ID
+	for i := len(checkpoints) - 1; i >= 0 && checkpoints[i].Turn == last.Turn; i-- {
+		id = checkpoints[i].ID
+	}
+	return c.restoreCheckpoint(id)
+}
+
+func describeCheckpoint(cp *session.Checkpoint) string {
+	paths := make([]string, 0, len(cp.Files))
+	for _, f := range cp.Files {
+		if !f.IsDir {
+			paths = append(paths, f.Path)
+		}
+	}
+	if len(paths) > 3 {
+		paths = append(paths[:3], fmt.Sprintf("and %d more", len(paths)-3))
+	}
+	return fmt.Sprintf(
+		"#%d turn %d %s at %s: %s",
+		cp.ID, cp.Turn, cp.ToolName, cp.Timestamp.Format(time.Kitchen), strings.Join(paths, ", "),
+	)
+}
+
+func (c *Chat) handleCheckpointsCommand() error {
+	checkpoints, err := c.cs.s.Checkpoints()
+	if err != nil {
+		return err
+	}
+	if len(checkpoints) == 0 {
+		fmt.Println("No checkpoints found")
+		return nil
+	}
+	// Newer one comes earlier.
+	items := make([]string, 0, len(checkpoints))
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		items = append(items, describeCheckpoint(checkpoints[i]))
+	}
+	sel := promptui.Select{
+		Label: "Select the checkpoint to restore the files to the state before it",
+		Items: items,
+		Size:  20,
+	}
+	idx, _, err := sel.Run()
+	if err != nil {
+		if err == promptui.ErrInterrupt {
+			return nil
+		}
+		return err
+	}
+	return c.restoreCheckpoint(checkpoints[len(checkpoints)-1-idx].ID)
+}
@@ -28,6 +28,14 @@ type Config struct {
 	LogLevel slog.Level `toml:"log_level"`
 	// Agents file name when specified.
 	AgentsFile string `toml:"agents_file"`
+	// The git integration.
+	Git GitConfig `toml:"git,omitempty"`
+	// The file tools.
+	Files FilesConfig `toml:"files,omitempty"`
+	// The command execution tools.
+	Exec ExecConfig `toml:"exec,omitempty"`
+	// The commands run on the events of the agent.
+	Hooks []HookConfig `toml:"hooks,omitempty"`
 }
 
 // ConfigFile returns the path of the config file.
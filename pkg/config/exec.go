@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultEnvDeny is the list of the patterns of the environment
+// variables which are not passed to the commands unless explicitly
+// allowed, to keep the credentials such as API keys away from them.
+var DefaultEnvDeny = []string{
+	"*_API_KEY",
+	"*_APIKEY",
+	"*_TOKEN",
+	"*_SECRET",
+	"*_SECRET_KEY",
+	"*_ACCESS_KEY",
+	"*_PASSWORD",
+	"*_CREDENTIALS",
+	"ANTHROPIC_*",
+	"OPENAI_*",
+	"GEMINI_*",
+}
+
+// ExecConfig defines the behavior of the command execution tools.
+type ExecConfig struct {
+	// Shell is the path of the shell to run the commands. It needs to
+	// be POSIX compatible. Defaults to /bin/sh; set "$SHELL" to use the
+	// login shell.
+	Shell string `toml:"shell,omitempty"`
+
+	// OneShot runs each command in a new shell process instead of the
+	// persistent shell which keeps the working directory and the
+	// environment variables across the commands.
+	OneShot bool `toml:"one_shot,omitempty"`
+
+	// Env controls the environment variables of the commands.
+	Env EnvConfig `toml:"env,omitempty"`
+
+	// Sandbox restricts what the executed commands can do.
+	Sandbox SandboxConfig `toml:"sandbox,omitempty"`
+}
+
+// EnvConfig controls the environment variables passed to the commands.
+// The patterns are in the syntax of path.Match, e.g. "GO*".
+type EnvConfig struct {
+	// Allow is the list of the patterns of the variables passed to the
+	// commands. When empty, all the variables are passed except the
+	// denied ones. The variables matching Allow are passed even if they
+	// match DefaultEnvDeny.
+	Allow []string `toml:"allow,omitempty"`
+
+	// Deny is the list of the patterns of the variables not passed, in
+	// addition to DefaultEnvDeny.
+	Deny []string `toml:"deny,omitempty"`
+
+	// Set is the variables set for all the commands.
+	Set map[string]string `toml:"set,omitempty"`
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the environment variables passed to the commands from
+// the list of "key=value", such as os.Environ().
+func (c EnvConfig) Filter(environ []string) []string {
+	var results []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		allowed := matchAny(c.Allow, name)
+		if len(c.Allow) > 0 && !allowed {
+			continue
+		}
+		if matchAny(c.Deny, name) || (!allowed && matchAny(DefaultEnvDeny, name)) {
+			continue
+		}
+		results = append(results, kv)
+	}
+	names := make([]string, 0, len(c.Set))
+	for name := range c.Set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		results = append(results, name+"="+c.Set[name])
+	}
+	return results
+}
+
+// ShellPath returns the path of the shell to run the commands.
+func (c ExecConfig) ShellPath() string {
+	// The login shell may not be POSIX compatible (e.g. fish), so it's
+	// used only when it's set explicitly as "$SHELL".
+	if shell := os.ExpandEnv(c.Shell); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+// SandboxConfig defines the sandbox for the executed commands. In the
+// sandbox, the project directory except .git is writable, the rest of the
+// filesystem is read-only and the network is disabled.
+//
+// Currently it requires bubblewrap (bwrap) on Linux.
+type SandboxConfig struct {
+	// Enabled runs the commands in the sandbox.
+	Enabled bool `toml:"enabled,omitempty"`
+
+	// AutoApprove runs the commands in the sandbox without asking the
+	// user for the confirmation.
+	AutoApprove bool `toml:"auto_approve,omitempty"`
+
+	// AllowNetwork keeps the network accessible from the sandbox.
+	AllowNetwork bool `toml:"allow_network,omitempty"`
+
+	// WritablePaths is the list of the paths writable in addition to
+	// the project directory, such as the build caches.
+	WritablePaths []string `toml:"writable_paths,omitempty"`
+
+	// MaxMemoryMB limits the virtual memory of each process.
+	MaxMemoryMB int `toml:"max_memory_mb,omitempty"`
+
+	// MaxCPUSeconds limits the CPU time of each process.
+	MaxCPUSeconds int `toml:"max_cpu_seconds,omitempty"`
+
+	// MaxFileSizeMB limits the size of the files created.
+	MaxFileSizeMB int `toml:"max_file_size_mb,omitempty"`
+
+	// MaxProcesses limits the number of the processes.
+	MaxProcesses int `toml:"max_processes,omitempty"`
+}
@@ -0,0 +1,33 @@
+package config
+
+const (
+	// DefaultMaxReadLines is the default of FilesConfig.MaxReadLines.
+	DefaultMaxReadLines = 2000
+	// DefaultMaxReadBytes is the default of FilesConfig.MaxReadBytes.
+	DefaultMaxReadBytes = 100 * 1024
+)
+
+// FilesConfig defines the behavior of the file tools.
+type FilesConfig struct {
+	// MaxReadLines is the max number of the lines read_file returns at
+	// once; the rest is truncated with a hint to continue.
+	MaxReadLines int `toml:"max_read_lines,omitempty"`
+
+	// MaxReadBytes is the max size of the content read_file returns at
+	// once.
+	MaxReadBytes int `toml:"max_read_bytes,omitempty"`
+}
+
+// ReadLimits returns the max lines and bytes for read_file, filling
+// the defaults.
+func (c FilesConfig) ReadLimits() (int, int) {
+	lines := c.MaxReadLines
+	if lines <= 0 {
+		lines = DefaultMaxReadLines
+	}
+	size := c.MaxReadBytes
+	if size <= 0 {
+		size = DefaultMaxReadBytes
+	}
+	return lines, size
+}
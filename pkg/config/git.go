@@ -0,0 +1,25 @@
+package config
+
+// DefaultBranchPrefix is the prefix of the work branches when
+// GitConfig.BranchPrefix is not specified.
+const DefaultBranchPrefix = "sylvan/"
+
+// GitConfig defines the behavior of the git integration.
+type GitConfig struct {
+	// AutoBranch creates (or switches to) a work branch for each
+	// session when the project is a git repository.
+	AutoBranch bool `toml:"auto_branch,omitempty"`
+
+	// BranchPrefix is the prefix of the work branch name, followed by
+	// the session ID.
+	BranchPrefix string `toml:"branch_prefix,omitempty"`
+}
+
+// WorkBranch returns the name of the work branch for the session.
+func (c GitConfig) WorkBranch(sessionID string) string {
+	prefix := c.BranchPrefix
+	if prefix == "" {
+		prefix = DefaultBranchPrefix
+	}
+	return prefix + sessionID
+}
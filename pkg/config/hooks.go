@@ -0,0 +1,38 @@
+package config
+
+// The events the hooks can be triggered on.
+const (
+	// HookPreToolUse is triggered before a tool is called; the hook can
+	// veto the call.
+	HookPreToolUse = "pre_tool_use"
+	// HookPostToolUse is triggered after a tool is called.
+	HookPostToolUse = "post_tool_use"
+	// HookUserPromptSubmit is triggered when the user sends a message,
+	// before it's sent to the agent.
+	HookUserPromptSubmit = "user_prompt_submit"
+	// HookTurnComplete is triggered when the agent finishes responding
+	// to a user message.
+	HookTurnComplete = "turn_complete"
+)
+
+// HookConfig defines a command run on an event of the agent.
+//
+// The command receives the JSON payload of the event from the standard
+// input. When it exits with the code 2, the event is blocked and the
+// standard error is given back to the agent; e.g. the tool call is
+// vetoed, or the agent continues the turn.
+type HookConfig struct {
+	// Event is the name of the event, one of the Hook* constants.
+	Event string `toml:"event"`
+
+	// Matcher is the regular expression matched with the tool name for
+	// the tool events. Matches all tools when empty.
+	Matcher string `toml:"matcher,omitempty"`
+
+	// Command is the command line run through the shell.
+	Command string `toml:"command"`
+
+	// TimeoutSeconds is the timeout of the command; 60 seconds when
+	// unspecified.
+	TimeoutSeconds int `toml:"timeout_seconds,omitempty"`
+}
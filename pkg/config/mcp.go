@@ -2,31 +2,214 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 )
 
 // MCPConfig defines a configuration to connect to a MCP server.
 //
 // It should specify either of the Command or Endpoint, not both.
-// RequestHeaders is an optional field only used for Endpoint.
+// RequestHeaders and Transport are optional fields only used for Endpoint,
+// and Env, EnvFrom and Cwd are only used for Command.
+//
+// The command, the environment variables, the working directory, the
+// endpoint and the request headers can refer to the variables:
+// ${PROJECT_ROOT} for the project directory and ${env:FOO} for the
+// environment variable FOO, so that the secrets aren't written in the
+// config.
 type MCPConfig struct {
 	// The name of the MCP Server/command.
 	Name string `toml:"name"`
 
 	// Command is the command-line to invoke the MCP.
 	Command []string `toml:"command,omitempty"`
+	// Env is the environment variables set for the command.
+	Env map[string]string `toml:"env,omitempty"`
+	// EnvFrom is the names of the environment variables forwarded to the
+	// command. When it's specified, only these variables are inherited
+	// from sylvan, so it needs to include the basic ones like PATH and
+	// HOME; otherwise the whole environment is inherited.
+	EnvFrom []string `toml:"env_from,omitempty"`
+	// Cwd is the working directory of the command; the current directory
+	// when unspecified.
+	Cwd string `toml:"cwd,omitempty"`
 
 	// The HTTP endpoint for the MCP server.
 	Endpoint string `toml:"endpoint,omitempty"`
 	// Additional HTTP request headers when a request is sent to the
 	// endpoint.
 	RequestHeaders map[string]string `toml:"request_headers,omitempty"`
+	// The transport to connect to the endpoint; auto-detected when
+	// unspecified.
+	Transport MCPTransport `toml:"transport,omitempty"`
+	// The OAuth client settings for the endpoint. The authorization
+	// starts when the server requires it, even without this.
+	OAuth MCPOAuthConfig `toml:"oauth,omitempty"`
+
+	// StartupTimeoutSeconds is the timeout to start and initialize the
+	// server; 30 seconds for the commands when unspecified. The endpoints
+	// have no timeout by default, since the OAuth authorization waits
+	// for the user.
+	StartupTimeoutSeconds int `toml:"startup_timeout_seconds,omitempty"`
+	// TimeoutSeconds is the timeout of each tool call; no timeout when
+	// unspecified.
+	TimeoutSeconds int `toml:"timeout_seconds,omitempty"`
+
+	// Disabled keeps the server in the config without connecting to it.
+	Disabled bool `toml:"disabled,omitempty"`
+}
+
+var mcpVariablePattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// expandMCPVariables replaces the variables like ${PROJECT_ROOT} in s.
+func expandMCPVariables(s, projectRoot string) (string, error) {
+	var err error
+	result := mcpVariablePattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := m[2 : len(m)-1]
+		if name == "PROJECT_ROOT" {
+			return projectRoot
+		}
+		if envName, ok := strings.CutPrefix(name, "env:"); ok {
+			return os.Getenv(envName)
+		}
+		if err == nil {
+			err = fmt.Errorf("unknown variable %s", m)
+		}
+		return m
+	})
+	return result, err
+}
+
+// Expand returns a copy of the config with the variables replaced.
+func (c MCPConfig) Expand(projectRoot string) (MCPConfig, error) {
+	var err error
+	expand := func(s string) string {
+		result, e := expandMCPVariables(s, projectRoot)
+		if err == nil && e != nil {
+			err = e
+		}
+		return result
+	}
+	expandMap := func(m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+		results := make(map[string]string, len(m))
+		for k, v := range m {
+			results[k] = expand(v)
+		}
+		return results
+	}
+	result := c
+	result.Command = nil
+	for _, arg := range c.Command {
+		result.Command = append(result.Command, expand(arg))
+	}
+	result.Env = expandMap(c.Env)
+	result.Cwd = expand(c.Cwd)
+	result.Endpoint = expand(c.Endpoint)
+	result.RequestHeaders = expandMap(c.RequestHeaders)
+	if err != nil {
+		return MCPConfig{}, fmt.Errorf("MCP server %s: %w", c.Name, err)
+	}
+	return result, nil
+}
+
+// Environ returns the environment variables of the command from the list
+// of "key=value", such as os.Environ().
+func (c MCPConfig) Environ(environ []string) []string {
+	var results []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if len(c.EnvFrom) == 0 || slices.Contains(c.EnvFrom, name) {
+			results = append(results, kv)
+		}
+	}
+	names := make([]string, 0, len(c.Env))
+	for name := range c.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		results = append(results, name+"="+c.Env[name])
+	}
+	return results
+}
+
+// The default startup timeout of the MCP commands.
+const defaultMCPStartupTimeout = 30 * time.Second
+
+// StartupTimeout returns the timeout to start the server, or zero for no
+// timeout.
+func (c MCPConfig) StartupTimeout() time.Duration {
+	if c.StartupTimeoutSeconds > 0 {
+		return time.Duration(c.StartupTimeoutSeconds) * time.Second
+	}
+	if c.Endpoint != "" {
+		return 0
+	}
+	return defaultMCPStartupTimeout
+}
+
+// Timeout returns the timeout of each tool call, or zero for no timeout.
+func (c MCPConfig) Timeout() time.Duration {
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// MCPOAuthConfig is the OAuth client settings for a MCP server.
+type MCPOAuthConfig struct {
+	// ClientID is the pre-registered client ID, for the servers which
+	// don't support the dynamic client registration.
+	ClientID string `toml:"client_id,omitempty"`
+	// ClientSecret is the secret of the pre-registered client.
+	ClientSecret string `toml:"client_secret,omitempty"`
+	// Scopes are the scopes to request.
+	Scopes []string `toml:"scopes,omitempty"`
+	// CallbackPort is the port of the local callback server; a random
+	// port is used when unspecified.
+	CallbackPort int `toml:"callback_port,omitempty"`
+}
+
+// MCPTransport is the HTTP transport of the MCP server.
+type MCPTransport string
+
+const (
+	// MCPTransportAuto tries the Streamable HTTP transport first, and
+	// falls back to the HTTP+SSE transport when it fails.
+	MCPTransportAuto MCPTransport = "auto"
+	// MCPTransportStreamable is the Streamable HTTP transport.
+	MCPTransportStreamable MCPTransport = "streamable"
+	// MCPTransportSSE is the deprecated HTTP+SSE transport, for the
+	// older servers.
+	MCPTransportSSE MCPTransport = "sse"
+)
+
+// UnmarshalText implements encoding.TextUnmarshaler interface.
+func (t *MCPTransport) UnmarshalText(text []byte) error {
+	switch v := MCPTransport(text); v {
+	case "", MCPTransportAuto, MCPTransportStreamable, MCPTransportSSE:
+		*t = v
+		return nil
+	default:
+		return fmt.Errorf("unknown MCP transport %q; must be one of %q, %q or %q",
+			v, MCPTransportAuto, MCPTransportStreamable, MCPTransportSSE)
+	}
 }
 
 // String implements Stringer interface.
 func (c MCPConfig) String() string {
+	var s string
 	if c.Endpoint != "" {
-		return fmt.Sprintf("%s: %s", c.Name, c.Endpoint)
+		s = fmt.Sprintf("%s: %s", c.Name, c.Endpoint)
+	} else {
+		s = fmt.Sprintf("%s: %s", c.Name, strings.Join(c.Command, " "))
+	}
+	if c.Disabled {
+		s += " (disabled)"
 	}
-	return fmt.Sprintf("%s: %s", c.Name, strings.Join(c.Command, " "))
+	return s
 }
@@ -0,0 +1,151 @@
+// package git provides a thin wrapper of the git command to inspect and
+// operate on the repository of the project.
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// EmptyTree is the object name of the empty tree, used as the base
+// of a repository without any commits.
+const EmptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// ErrNotRepository is returned when the directory is not in a git repository.
+var ErrNotRepository = errors.New("not a git repository")
+
+// Repo is a git repository.
+type Repo struct {
+	dir string
+}
+
+// Open opens the git repository containing the directory.
+func Open(ctx context.Context, dir string) (*Repo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, ErrNotRepository
+	}
+	r := &Repo{dir: dir}
+	top, err := r.Run(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, ErrNotRepository
+	}
+	r.dir = strings.TrimSpace(top)
+	return r, nil
+}
+
+// Dir returns the top-level directory of the repository.
+func (r *Repo) Dir() string {
+	return r.dir
+}
+
+// Run runs the git command with the arguments in the repository and
+// returns its standard output.
+func (r *Repo) Run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.dir
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+// Head returns the commit ID of HEAD, or an empty string if there are
+// no commits yet.
+func (r *Repo) Head(ctx context.Context) (string, error) {
+	out, err := r.Run(ctx, "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		// rev-parse --verify --quiet exits with 1 silently for unborn HEAD.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Snapshot returns an object name which represents the current state of
+// the tracked files including uncommitted changes, without modifying the
+// working tree, the index, or the refs.
+func (r *Repo) Snapshot(ctx context.Context) (string, error) {
+	head, err := r.Head(ctx)
+	if err != nil {
+		return "", err
+	}
+	if head == "" {
+		return EmptyTree, nil
+	}
+	out, err := r.Run(ctx, "stash", "create")
+	if err != nil {
+		return "", err
+	}
+	if stash := strings.TrimSpace(out); stash != "" {
+		return stash, nil
+	}
+	return head, nil
+}
+
+// CurrentBranch returns the name of the current branch, or an empty
+// string when HEAD is detached.
+func (r *Repo) CurrentBranch(ctx context.Context) (string, error) {
+	out, err := r.Run(ctx, "branch", "--show-current")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// SwitchBranch switches to the branch, creating it from the current HEAD
+// when it doesn't exist. Uncommitted changes are carried over.
+func (r *Repo) SwitchBranch(ctx context.Context, name string) error {
+	if _, err := r.Run(ctx, "rev-parse", "--verify", "--quiet", "refs/heads/"+name); err == nil {
+		_, err := r.Run(ctx, "switch", name)
+		return err
+	}
+	_, err := r.Run(ctx, "switch", "-c", name)
+	return err
+}
+
+// Untracked returns the list of the untracked files which are not ignored.
+func (r *Repo) Untracked(ctx context.Context) ([]string, error) {
+	out, err := r.Run(ctx, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for f := range strings.SplitSeq(out, "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// Diff returns the diff between the base and the working tree. Untracked
+// files are not included.
+func (r *Repo) Diff(ctx context.Context, base string, args ...string) (string, error) {
+	return r.Run(ctx, append([]string{"diff", base}, args...)...)
+}
+
+// CommitAll stages all the changes including untracked files and
+// creates a commit with the message.
+func (r *Repo) CommitAll(ctx context.Context, message string) (string, error) {
+	if _, err := r.Run(ctx, "add", "--all"); err != nil {
+		return "", err
+	}
+	if _, err := r.Run(ctx, "commit", "--quiet", "--message", message); err != nil {
+		return "", err
+	}
+	return r.Head(ctx)
+}
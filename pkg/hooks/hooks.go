@@ -0,0 +1,192 @@
+// package hooks runs the user-defined commands on the events of the agent,
+// such as formatting the files after every edit or blocking certain tool
+// calls.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmuk/sylvan/pkg/config"
+)
+
+// The exit code of a hook to block the event.
+const blockExitCode = 2
+
+var defaultTimeout = time.Minute
+
+// Payload is the data passed to the hooks as JSON through the standard
+// input.
+type Payload struct {
+	// The name of the event.
+	Event string `json:"event"`
+	// The ID of the chat session.
+	SessionID string `json:"session_id"`
+	// The project directory.
+	Cwd string `json:"cwd"`
+	// The name of the tool for the tool events.
+	ToolName string `json:"tool_name,omitempty"`
+	// The arguments of the tool call for the tool events.
+	ToolInput map[string]any `json:"tool_input,omitempty"`
+	// The response of the tool for post_tool_use.
+	ToolResponse any `json:"tool_response,omitempty"`
+	// The error of the tool for post_tool_use.
+	ToolError string `json:"tool_error,omitempty"`
+	// The message of the user for user_prompt_submit.
+	Prompt string `json:"prompt,omitempty"`
+	// True for turn_complete when the turn is already continued by a
+	// hook, to avoid the infinite loops.
+	Continued bool `json:"continued,omitempty"`
+}
+
+// Result is the result of running the hooks of an event.
+type Result struct {
+	// Blocked is true if a hook exited with the code 2.
+	Blocked bool
+	// Feedback is the standard error of the blocking hooks, or the
+	// standard output of the successful ones.
+	Feedback string
+}
+
+type hook struct {
+	config.HookConfig
+	matcher *regexp.Regexp
+}
+
+// Runner runs the hooks.
+type Runner struct {
+	hooks  []*hook
+	dir    string
+	shell  string
+	logger *slog.Logger
+}
+
+// New creates a new Runner for the hooks, running the commands with the
+// shell in the directory.
+func New(configs []config.HookConfig, dir, shell string, logger *slog.Logger) (*Runner, error) {
+	r := &Runner{dir: dir, shell: shell, logger: logger}
+	for i, c := range configs {
+		switch c.Event {
+		case config.HookPreToolUse, config.HookPostToolUse, config.HookUserPromptSubmit, config.HookTurnComplete:
+		default:
+			return nil, fmt.Errorf("hook %d: unknown event %q", i, c.Event)
+		}
+		if c.Command == "" {
+			return nil, fmt.Errorf("hook %d: command is empty", i)
+		}
+		h := &hook{HookConfig: c}
+		if c.Matcher != "" {
+			var err error
+			if h.matcher, err = regexp.Compile(c.Matcher); err != nil {
+				return nil, fmt.Errorf("hook %d: %w", i, err)
+			}
+		}
+		r.hooks = append(r.hooks, h)
+	}
+	return r, nil
+}
+
+// Has returns true if any hooks are defined for the event.
+func (r *Runner) Has(event string) bool {
+	for _, h := range r.hooks {
+		if h.Event == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs the hooks matching the payload in the order of the config.
+// The failures of the hooks other than blocking are reported to the user
+// but don't stop the event.
+func (r *Runner) Run(ctx context.Context, p *Payload) (*Result, error) {
+	result := &Result{}
+	var input []byte
+	var feedbacks []string
+	for _, h := range r.hooks {
+		if h.Event != p.Event {
+			continue
+		}
+		if h.matcher != nil && !h.matcher.MatchString(p.ToolName) {
+			continue
+		}
+		if input == nil {
+			var err error
+			if input, err = json.Marshal(p); err != nil {
+				return nil, err
+			}
+		}
+		stdout, stderr, code, err := r.runHook(ctx, h, input)
+		logger := r.logger.With("event", p.Event, "command", h.Command)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// No more hooks run once the caller is canceled.
+			return nil, ctxErr
+		}
+		if err != nil {
+			logger.Error("Failed to run the hook", "error", err)
+			fmt.Printf("Hook %q failed: %v\n", h.Command, err)
+			continue
+		}
+		logger.Debug("Hook completed", "exit_code", code, "stdout", stdout, "stderr", stderr)
+		switch code {
+		case 0:
+			if s := strings.TrimSpace(stdout); s != "" {
+				feedbacks = append(feedbacks, s)
+			}
+		case blockExitCode:
+			if !result.Blocked {
+				// The feedback of the blocking hooks wins.
+				feedbacks = nil
+			}
+			result.Blocked = true
+			if s := strings.TrimSpace(stderr); s != "" {
+				feedbacks = append(feedbacks, s)
+			}
+		default:
+			fmt.Printf("Hook %q exited with %d: %s\n", h.Command, code, strings.TrimSpace(stderr))
+		}
+		if result.Blocked && p.Event == config.HookPreToolUse {
+			break
+		}
+	}
+	result.Feedback = strings.Join(feedbacks, "\n")
+	return result, nil
+}
+
+func (r *Runner) runHook(ctx context.Context, h *hook, input []byte) (string, string, int, error) {
+	timeout := defaultTimeout
+	if h.TimeoutSeconds > 0 {
+		timeout = time.Duration(h.TimeoutSeconds) * time.Second
+	}
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(hookCtx, r.shell, "-c", h.Command)
+	cmd.Dir = r.dir
+	cmd.Stdin = bytes.NewReader(input)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// Canceled by the caller, e.g. the user interrupted.
+		return "", "", 0, ctxErr
+	}
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", "", 0, err
+	}
+	if errors.Is(hookCtx.Err(), context.DeadlineExceeded) {
+		return "", "", 0, fmt.Errorf("timed out after %s", timeout)
+	}
+	return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode(), nil
+}
@@ -0,0 +1,156 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// errNotFound is returned when the metadata doesn't exist at the URL.
+var errNotFound = errors.New("not found")
+
+// resourceMetadata is the OAuth 2.0 protected resource metadata (RFC 9728).
+type resourceMetadata struct {
+	Resource             string   `json:"resource"`
+	AuthorizationServers []string `json:"authorization_servers"`
+	ScopesSupported      []string `json:"scopes_supported,omitempty"`
+}
+
+// serverMetadata is the OAuth 2.0 authorization server metadata (RFC 8414).
+type serverMetadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	RegistrationEndpoint          string   `json:"registration_endpoint,omitempty"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// challenge is the parsed Bearer challenge of the WWW-Authenticate header.
+type challenge struct {
+	resourceMetadata string
+	scope            string
+}
+
+// parseChallenge parses the parameters of the Bearer challenge in the
+// WWW-Authenticate header, e.g.
+// `Bearer resource_metadata="https://...", scope="read"`.
+func parseChallenge(header string) challenge {
+	var c challenge
+	scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return c
+	}
+	for params != "" {
+		var key, value string
+		key, params, _ = strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		if strings.HasPrefix(params, `"`) {
+			value, params, _ = strings.Cut(params[1:], `"`)
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		switch strings.TrimSpace(key) {
+		case "resource_metadata":
+			c.resourceMetadata = value
+		case "scope":
+			c.scope = value
+		}
+	}
+	return c
+}
+
+// wellKnownURLs returns the candidate URLs of the well-known metadata for
+// the URL. The well-known path is inserted between the host and the path,
+// and the root of the host is tried at last.
+func wellKnownURLs(u *url.URL, name string) []string {
+	origin := u.Scheme + "://" + u.Host
+	p := strings.TrimSuffix(u.Path, "/")
+	if p == "" {
+		return []string{origin + "/.well-known/" + name}
+	}
+	return []string{
+		origin + "/.well-known/" + name + p,
+		origin + "/.well-known/" + name,
+	}
+}
+
+func getJSON(ctx context.Context, client *http.Client, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s: %s: %s", u, resp.Status, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discoverResource fetches the protected resource metadata. It returns
+// nil when the resource doesn't publish it.
+func discoverResource(ctx context.Context, client *http.Client, resource *url.URL, c challenge) (*resourceMetadata, error) {
+	candidates := wellKnownURLs(resource, "oauth-protected-resource")
+	if c.resourceMetadata != "" {
+		candidates = []string{c.resourceMetadata}
+	}
+	for _, u := range candidates {
+		meta := &resourceMetadata{}
+		err := getJSON(ctx, client, u, meta)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(meta.AuthorizationServers) == 0 {
+			return nil, fmt.Errorf("no authorization servers in %s", u)
+		}
+		return meta, nil
+	}
+	return nil, nil
+}
+
+// discoverServer fetches the metadata of the authorization server. When
+// the server doesn't publish it, the default endpoints are used.
+func discoverServer(ctx context.Context, client *http.Client, issuer string) (*serverMetadata, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, err
+	}
+	candidates := wellKnownURLs(u, "oauth-authorization-server")
+	candidates = append(candidates, wellKnownURLs(u, "openid-configuration")...)
+	for _, c := range candidates {
+		meta := &serverMetadata{}
+		err := getJSON(ctx, client, c, meta)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" {
+			return nil, fmt.Errorf("missing endpoints in %s", c)
+		}
+		return meta, nil
+	}
+	origin := u.Scheme + "://" + u.Host
+	return &serverMetadata{
+		Issuer:                origin,
+		AuthorizationEndpoint: origin + "/authorize",
+		TokenEndpoint:         origin + "/token",
+		RegistrationEndpoint:  origin + "/register",
+	}, nil
+}
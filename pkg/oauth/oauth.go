@@ -0,0 +1,417 @@
+// package oauth implements the OAuth authorization of the MCP servers;
+// the authorization code flow with PKCE, the dynamic client registration,
+// and the automatic refresh of the tokens.
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmuk/sylvan/pkg/config"
+)
+
+// The time to wait for the user to complete the authorization.
+const authorizationTimeout = 5 * time.Minute
+
+const callbackPath = "/callback"
+
+// Authorizer authorizes the requests to a MCP server.
+type Authorizer struct {
+	name     string
+	resource string
+	config   config.MCPOAuthConfig
+	// client is used for the requests to the authorization server.
+	client *http.Client
+	// showURL asks the user to open the authorization URL.
+	showURL func(authURL string)
+
+	mu     sync.Mutex
+	loaded bool
+	creds  *credentials
+	// refreshed is the access token obtained by refreshing after the
+	// server rejected the previous one.
+	refreshed string
+}
+
+// NewAuthorizer creates a new Authorizer for the MCP server at the
+// endpoint. The name is shown to the user when the authorization is
+// needed.
+func NewAuthorizer(name, endpoint string, c config.MCPOAuthConfig) *Authorizer {
+	return &Authorizer{
+		name:     name,
+		resource: endpoint,
+		config:   c,
+		client:   http.DefaultClient,
+		showURL: func(authURL string) {
+			fmt.Printf("%s requires the authorization. Open the following URL in the browser:\n%s\n", name, authURL)
+		},
+	}
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// pkceChallenge returns the S256 code challenge for the verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// load loads the stored credentials once. a.mu must be held.
+func (a *Authorizer) load() error {
+	if a.loaded {
+		return nil
+	}
+	creds, err := loadCredentials(a.resource)
+	if err != nil {
+		return err
+	}
+	a.creds = creds
+	a.loaded = true
+	return nil
+}
+
+// token returns the access token, refreshing it when it's expired. It
+// returns an empty string when not authorized yet.
+func (a *Authorizer) token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.load(); err != nil {
+		return "", err
+	}
+	if a.creds == nil || a.creds.Token == nil {
+		return "", nil
+	}
+	if a.creds.Token.valid() {
+		return a.creds.Token.AccessToken, nil
+	}
+	if a.creds.Token.RefreshToken == "" {
+		return "", nil
+	}
+	if err := a.refresh(ctx); err != nil {
+		// The server will ask for the authorization again.
+		return "", nil
+	}
+	return a.creds.Token.AccessToken, nil
+}
+
+// authorize obtains a new access token after the server rejected the
+// failed token. It refreshes the token if possible, otherwise runs the
+// authorization code flow with the user.
+func (a *Authorizer) authorize(ctx context.Context, failed string, wwwAuthenticate string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.load(); err != nil {
+		return "", err
+	}
+	if a.creds != nil && a.creds.Token != nil {
+		if tok := a.creds.Token; tok.AccessToken != failed && tok.valid() {
+			// Already authorized by another request.
+			return tok.AccessToken, nil
+		}
+		// The token may be revoked before its expiry; try refreshing
+		// unless the refreshed token is rejected too.
+		if a.creds.Token.RefreshToken != "" && (failed == "" || failed != a.refreshed) {
+			if err := a.refresh(ctx); err == nil {
+				a.refreshed = a.creds.Token.AccessToken
+				return a.creds.Token.AccessToken, nil
+			}
+		}
+	}
+
+	ch := parseChallenge(wwwAuthenticate)
+	resourceURL, err := url.Parse(a.resource)
+	if err != nil {
+		return "", err
+	}
+	rm, err := discoverResource(ctx, a.client, resourceURL, ch)
+	if err != nil {
+		return "", fmt.Errorf("failed to discover the resource metadata: %w", err)
+	}
+	issuer := resourceURL.Scheme + "://" + resourceURL.Host
+	if rm != nil {
+		issuer = rm.AuthorizationServers[0]
+	}
+	sm, err := discoverServer(ctx, a.client, issuer)
+	if err != nil {
+		return "", fmt.Errorf("failed to discover the authorization server: %w", err)
+	}
+	scope := strings.Join(a.config.Scopes, " ")
+	if scope == "" {
+		scope = ch.scope
+	}
+
+	listener, err := a.listen()
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://%s%s", listener.Addr(), callbackPath)
+
+	creds, err := a.registration(ctx, sm, issuer, redirectURI)
+	if err != nil {
+		return "", err
+	}
+	code, verifier, err := a.authorizationCode(ctx, listener, sm, creds, redirectURI, scope)
+	if err != nil {
+		return "", err
+	}
+	tok, err := a.requestToken(ctx, sm.TokenEndpoint, creds, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return "", err
+	}
+	creds.TokenEndpoint = sm.TokenEndpoint
+	creds.Token = tok
+	a.creds = creds
+	if err := saveCredentials(creds); err != nil {
+		return "", err
+	}
+	fmt.Printf("Authorized %s\n", a.name)
+	return tok.AccessToken, nil
+}
+
+// listen starts listening on the loopback address for the callback. The
+// port of the registered redirect URI is reused when possible.
+func (a *Authorizer) listen() (net.Listener, error) {
+	port := a.config.CallbackPort
+	if port == 0 && a.creds != nil && a.creds.RedirectURI != "" {
+		if u, err := url.Parse(a.creds.RedirectURI); err == nil {
+			port, _ = strconv.Atoi(u.Port())
+		}
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil && port != 0 && a.config.CallbackPort == 0 {
+		// The port is used by others; register a new redirect URI.
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	return l, err
+}
+
+// registration returns the credentials of the client for the server,
+// registering a new client if needed.
+func (a *Authorizer) registration(ctx context.Context, sm *serverMetadata, issuer, redirectURI string) (*credentials, error) {
+	if a.config.ClientID != "" {
+		return &credentials{
+			Resource:     a.resource,
+			Issuer:       issuer,
+			ClientID:     a.config.ClientID,
+			ClientSecret: a.config.ClientSecret,
+			RedirectURI:  redirectURI,
+		}, nil
+	}
+	if c := a.creds; c != nil && c.Issuer == issuer && c.RedirectURI == redirectURI && c.ClientID != "" {
+		return &credentials{
+			Resource:     a.resource,
+			Issuer:       issuer,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			RedirectURI:  redirectURI,
+		}, nil
+	}
+	if sm.RegistrationEndpoint == "" {
+		return nil, fmt.Errorf("%s doesn't support the dynamic client registration; set oauth.client_id in the config", issuer)
+	}
+	body, err := json.Marshal(map[string]any{
+		"client_name":                "sylvan",
+		"redirect_uris":              []string{redirectURI},
+		"grant_types":                []string{"authorization_code", "refresh_token"},
+		"response_types":             []string{"code"},
+		"token_endpoint_auth_method": "none",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sm.RegistrationEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to register the client: %s: %s", resp.Status, msg)
+	}
+	var registered struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&registered); err != nil {
+		return nil, err
+	}
+	if registered.ClientID == "" {
+		return nil, errors.New("no client_id in the registration response")
+	}
+	return &credentials{
+		Resource:     a.resource,
+		Issuer:       issuer,
+		ClientID:     registered.ClientID,
+		ClientSecret: registered.ClientSecret,
+		RedirectURI:  redirectURI,
+	}, nil
+}
+
+// authorizationCode asks the user to open the authorization URL and waits
+// for the callback. It returns the authorization code and the PKCE code
+// verifier.
+func (a *Authorizer) authorizationCode(ctx context.Context, listener net.Listener, sm *serverMetadata, creds *credentials, redirectURI, scope string) (string, string, error) {
+	verifier := randomString(32)
+	state := randomString(16)
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {creds.ClientID},
+		"redirect_uri":          {redirectURI},
+		"state":                 {state},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+		"resource":              {a.resource},
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	authURL, err := url.Parse(sm.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", err
+	}
+	if authURL.RawQuery != "" {
+		authURL.RawQuery += "&" + q.Encode()
+	} else {
+		authURL.RawQuery = q.Encode()
+	}
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var res result
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			res.err = fmt.Errorf("authorization failed: %s %s", q.Get("error"), q.Get("error_description"))
+		case q.Get("code") == "":
+			res.err = errors.New("no authorization code in the callback")
+		default:
+			res.code = q.Get("code")
+		}
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "The authorization is completed. You can close this window.")
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	a.showURL(authURL.String())
+	ctx, cancel := context.WithTimeout(ctx, authorizationTimeout)
+	defer cancel()
+	select {
+	case res := <-results:
+		return res.code, verifier, res.err
+	case <-ctx.Done():
+		return "", "", fmt.Errorf("waiting for the authorization: %w", ctx.Err())
+	}
+}
+
+// refresh refreshes the token. a.mu must be held.
+func (a *Authorizer) refresh(ctx context.Context) error {
+	tok, err := a.requestToken(ctx, a.creds.TokenEndpoint, a.creds, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {a.creds.Token.RefreshToken},
+	})
+	if err != nil {
+		return err
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = a.creds.Token.RefreshToken
+	}
+	a.creds.Token = tok
+	return saveCredentials(a.creds)
+}
+
+// requestToken sends the token request with the client credentials.
+func (a *Authorizer) requestToken(ctx context.Context, endpoint string, creds *credentials, form url.Values) (*Token, error) {
+	form.Set("client_id", creds.ClientID)
+	if creds.ClientSecret != "" {
+		form.Set("client_secret", creds.ClientSecret)
+	}
+	form.Set("resource", a.resource)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tr struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("failed to parse the token response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s: %s %s", resp.Status, tr.Error, tr.ErrorDescription)
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("no access_token in the token response")
+	}
+	tok := &Token{
+		AccessToken:  tr.AccessToken,
+		TokenType:    tr.TokenType,
+		RefreshToken: tr.RefreshToken,
+	}
+	if tr.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
@@ -0,0 +1,342 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmuk/sylvan/pkg/config"
+)
+
+// fakeServer is a fake authorization server which also serves the
+// protected MCP endpoint at /mcp.
+type fakeServer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu sync.Mutex
+	// The registered clients and their redirect URIs.
+	clients map[string]string
+	// The code challenges of the issued authorization codes.
+	challenges map[string]string
+	// The valid access tokens and refresh tokens.
+	accessTokens  map[string]bool
+	refreshTokens map[string]bool
+	nextID        int
+	// The grant types of the token requests.
+	grants []string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	// The credentials are stored under the user config directory.
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	fs := &fakeServer{
+		t:             t,
+		clients:       map[string]string{},
+		challenges:    map[string]string{},
+		accessTokens:  map[string]bool{},
+		refreshTokens: map[string]bool{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-protected-resource/mcp", fs.resourceMetadata)
+	mux.HandleFunc("/.well-known/oauth-authorization-server", fs.serverMetadata)
+	mux.HandleFunc("/register", fs.register)
+	mux.HandleFunc("/authorize", fs.authorize)
+	mux.HandleFunc("/token", fs.token)
+	mux.HandleFunc("/mcp", fs.mcp)
+	fs.srv = httptest.NewServer(mux)
+	t.Cleanup(fs.srv.Close)
+	return fs
+}
+
+func (fs *fakeServer) newID(prefix string) string {
+	fs.nextID++
+	return fmt.Sprintf("%s-%d", prefix, fs.nextID)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (fs *fakeServer) resourceMetadata(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &resourceMetadata{
+		Resource:             fs.srv.URL + "/mcp",
+		AuthorizationServers: []string{fs.srv.URL},
+	})
+}
+
+func (fs *fakeServer) serverMetadata(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &serverMetadata{
+		Issuer:                        fs.srv.URL,
+		AuthorizationEndpoint:         fs.srv.URL + "/authorize",
+		TokenEndpoint:                 fs.srv.URL + "/token",
+		RegistrationEndpoint:          fs.srv.URL + "/register",
+		CodeChallengeMethodsSupported: []string{"S256"},
+	})
+}
+
+func (fs *fakeServer) register(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RedirectURIs []string `json:"redirect_uris"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.RedirectURIs) != 1 {
+		http.Error(w, "invalid registration", http.StatusBadRequest)
+		return
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	id := fs.newID("client")
+	fs.clients[id] = req.RedirectURIs[0]
+	writeJSON(w, http.StatusCreated, map[string]string{"client_id": id})
+}
+
+func (fs *fakeServer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	redirectURI, ok := fs.clients[q.Get("client_id")]
+	if !ok || redirectURI != q.Get("redirect_uri") {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE is required", http.StatusBadRequest)
+		return
+	}
+	if q.Get("resource") != fs.srv.URL+"/mcp" {
+		fs.t.Errorf("got resource %q, want %q", q.Get("resource"), fs.srv.URL+"/mcp")
+	}
+	code := fs.newID("code")
+	fs.challenges[code] = q.Get("code_challenge")
+	http.Redirect(w, r, redirectURI+"?"+url.Values{
+		"code":  {code},
+		"state": {q.Get("state")},
+	}.Encode(), http.StatusFound)
+}
+
+func (fs *fakeServer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	grant := r.PostForm.Get("grant_type")
+	fs.grants = append(fs.grants, grant)
+	switch grant {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		challenge, ok := fs.challenges[code]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(fs.challenges, code)
+		if got := pkceChallenge(r.PostForm.Get("code_verifier")); got != challenge {
+			fs.t.Errorf("the code_verifier doesn't match the challenge: got %q, want %q", got, challenge)
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+	case "refresh_token":
+		if !fs.refreshTokens[r.PostForm.Get("refresh_token")] {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	access := fs.newID("access")
+	refresh := fs.newID("refresh")
+	fs.accessTokens[access] = true
+	fs.refreshTokens[refresh] = true
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"refresh_token": refresh,
+		"expires_in":    3600,
+	})
+}
+
+func (fs *fakeServer) mcp(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	ok := fs.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	fs.mu.Unlock()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer resource_metadata="%s/.well-known/oauth-protected-resource/mcp"`, fs.srv.URL))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// Echo the body to check it's resent on the retry.
+	io.Copy(w, r.Body)
+}
+
+// revoke invalidates all the issued access tokens.
+func (fs *fakeServer) revoke() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	clear(fs.accessTokens)
+}
+
+// newTestAuthorizer returns the authorizer for the fake server, which
+// completes the authorization in the browser by following the redirects.
+func newTestAuthorizer(t *testing.T, fs *fakeServer) *Authorizer {
+	a := NewAuthorizer("test", fs.srv.URL+"/mcp", config.MCPOAuthConfig{})
+	a.client = fs.srv.Client()
+	a.showURL = func(authURL string) {
+		go func() {
+			resp, err := http.Get(authURL)
+			if err != nil {
+				t.Errorf("failed to open %s: %v", authURL, err)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("the authorization failed: %s: %s", resp.Status, body)
+			}
+		}()
+	}
+	return a
+}
+
+// post sends a request to the MCP endpoint through the authorizer.
+func post(t *testing.T, a *Authorizer, fs *fakeServer, body string) {
+	t.Helper()
+	client := &http.Client{Transport: a.RoundTripper(http.DefaultTransport)}
+	resp, err := client.Post(fs.srv.URL+"/mcp", "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(got) != body {
+		t.Fatalf("got %s %q, want 200 %q", resp.Status, got, body)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	fs := newFakeServer(t)
+	ctx := context.Background()
+	resource, err := url.Parse(fs.srv.URL + "/mcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []challenge{
+		{},
+		parseChallenge(fmt.Sprintf(`Bearer resource_metadata="%s/.well-known/oauth-protected-resource/mcp", scope="read"`, fs.srv.URL)),
+	} {
+		rm, err := discoverResource(ctx, fs.srv.Client(), resource, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rm == nil || len(rm.AuthorizationServers) != 1 || rm.AuthorizationServers[0] != fs.srv.URL {
+			t.Errorf("got %+v for %+v, want the authorization server %s", rm, c, fs.srv.URL)
+		}
+	}
+
+	sm, err := discoverServer(ctx, fs.srv.Client(), fs.srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.TokenEndpoint != fs.srv.URL+"/token" || sm.RegistrationEndpoint != fs.srv.URL+"/register" {
+		t.Errorf("got %+v", sm)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	c := parseChallenge(`Bearer error="invalid_token", resource_metadata="https://example.com/meta", scope="read write"`)
+	if c.resourceMetadata != "https://example.com/meta" || c.scope != "read write" {
+		t.Errorf("got %+v", c)
+	}
+	if c := parseChallenge(`Basic realm="x"`); c != (challenge{}) {
+		t.Errorf("got %+v for the basic challenge", c)
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	fs := newFakeServer(t)
+	a := newTestAuthorizer(t, fs)
+	post(t, a, fs, "hello")
+
+	if len(fs.clients) != 1 {
+		t.Errorf("got %d registered clients, want 1", len(fs.clients))
+	}
+	if got := strings.Join(fs.grants, ","); got != "authorization_code" {
+		t.Errorf("got the grants %s, want authorization_code", got)
+	}
+	creds, err := loadCredentials(a.resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds == nil || creds.Token == nil || creds.Token.RefreshToken == "" {
+		t.Fatalf("the credentials are not stored: %+v", creds)
+	}
+
+	// The stored token is used by a new authorizer without the browser.
+	b := newTestAuthorizer(t, fs)
+	b.showURL = func(string) { t.Error("unexpected authorization") }
+	post(t, b, fs, "again")
+	if len(fs.grants) != 1 {
+		t.Errorf("got the grants %v, want no more token requests", fs.grants)
+	}
+}
+
+func TestRefreshExpiredToken(t *testing.T) {
+	fs := newFakeServer(t)
+	a := newTestAuthorizer(t, fs)
+	post(t, a, fs, "hello")
+
+	a.showURL = func(string) { t.Error("unexpected authorization") }
+	a.creds.Token.Expiry = time.Now().Add(-time.Minute)
+	post(t, a, fs, "refreshed")
+	if got := strings.Join(fs.grants, ","); got != "authorization_code,refresh_token" {
+		t.Errorf("got the grants %s", got)
+	}
+}
+
+func TestRetryWithRevokedToken(t *testing.T) {
+	fs := newFakeServer(t)
+	a := newTestAuthorizer(t, fs)
+	post(t, a, fs, "hello")
+
+	// The token without the expiry is rejected; the transport refreshes
+	// it and resends the request.
+	a.showURL = func(string) { t.Error("unexpected authorization") }
+	a.creds.Token.Expiry = time.Time{}
+	fs.revoke()
+	post(t, a, fs, "retried")
+	if got := strings.Join(fs.grants, ","); got != "authorization_code,refresh_token" {
+		t.Errorf("got the grants %s", got)
+	}
+}
+
+func TestRetryFallsBackToAuthorization(t *testing.T) {
+	fs := newFakeServer(t)
+	a := newTestAuthorizer(t, fs)
+	post(t, a, fs, "hello")
+
+	// The refresh token is rejected too; the user authorizes again.
+	fs.revoke()
+	fs.mu.Lock()
+	clear(fs.refreshTokens)
+	fs.mu.Unlock()
+	post(t, a, fs, "reauthorized")
+	if got := strings.Join(fs.grants, ","); got != "authorization_code,refresh_token,authorization_code" {
+		t.Errorf("got the grants %s", got)
+	}
+}
@@ -0,0 +1,116 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The margin before the expiry to refresh the token.
+const expiryDelta = 30 * time.Second
+
+// Token is the token issued by the authorization server.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitzero"`
+}
+
+// valid returns true if the access token can be used now.
+func (t *Token) valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || time.Now().Add(expiryDelta).Before(t.Expiry)
+}
+
+// credentials is the client registration and the token for a resource,
+// stored across the sessions.
+type credentials struct {
+	// Resource is the URL of the MCP server.
+	Resource string `json:"resource"`
+	// Issuer is the authorization server the client is registered to.
+	Issuer        string `json:"issuer"`
+	TokenEndpoint string `json:"token_endpoint"`
+	ClientID      string `json:"client_id"`
+	ClientSecret  string `json:"client_secret,omitempty"`
+	// RedirectURI is the redirect URI registered for the client.
+	RedirectURI string `json:"redirect_uri,omitempty"`
+	Token       *Token `json:"token,omitempty"`
+}
+
+// storeDir returns the directory to store the credentials.
+func storeDir() (string, error) {
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userConfigDir, "sylvan", "oauth"), nil
+}
+
+func credentialsFile(resource string) (string, error) {
+	dir, err := storeDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(resource))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// loadCredentials loads the stored credentials for the resource. It returns
+// nil when nothing is stored.
+func loadCredentials(resource string) (*credentials, error) {
+	filename, err := credentialsFile(resource)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &credentials{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Resource != resource {
+		// Hash collision; treat as not stored.
+		return nil, nil
+	}
+	return c, nil
+}
+
+// saveCredentials stores the credentials, readable only by the user.
+func saveCredentials(c *credentials) error {
+	filename, err := credentialsFile(c.Resource)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+// DeleteCredentials removes the stored credentials for the resource, so
+// that the authorization starts over next time.
+func DeleteCredentials(resource string) error {
+	filename, err := credentialsFile(resource)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
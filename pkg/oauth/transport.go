@@ -0,0 +1,63 @@
+package oauth
+
+import (
+	"io"
+	"net/http"
+)
+
+type roundTripper struct {
+	auth *Authorizer
+	base http.RoundTripper
+}
+
+// RoundTripper returns a http.RoundTripper which adds the access token to
+// the requests, and authorizes when the server responds 401.
+//
+// The requests which already have the Authorization header, e.g. by
+// the request headers in the config, are sent as they are.
+func (a *Authorizer) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	return &roundTripper{auth: a, base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Header.Get("Authorization") != "" {
+		return rt.base.RoundTrip(r)
+	}
+	ctx := r.Context()
+	tok, err := rt.auth.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rt.base.RoundTrip(withToken(r, tok))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if r.Body != nil && r.GetBody == nil {
+		// Can't resend the request.
+		return resp, nil
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	tok, err = rt.auth.authorize(ctx, tok, resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return nil, err
+	}
+	retry := withToken(r, tok)
+	if r.GetBody != nil {
+		if retry.Body, err = r.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return rt.base.RoundTrip(retry)
+}
+
+// withToken returns a copy of the request with the bearer token.
+func withToken(r *http.Request, tok string) *http.Request {
+	r = r.Clone(r.Context())
+	if tok != "" {
+		r.Header.Set("Authorization", "Bearer "+tok)
+	}
+	return r
+}
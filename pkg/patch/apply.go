@@ -0,0 +1,267 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// The max number of the context lines ignored at the beginning and
+	// at the end of a hunk when it doesn't match.
+	maxFuzz = 2
+	// The number of lines shown around the place where a failed hunk
+	// is expected.
+	failureContextLines = 3
+)
+
+// HunkResult describes how a hunk is applied.
+type HunkResult struct {
+	// The index of the hunk in the file diff.
+	Index int
+	// Whether the hunk is applied or not.
+	Applied bool
+	// The line number (1-based) in the original content where the hunk
+	// is applied, or is expected when it failed.
+	Line int
+	// The difference between Line and the line number in the hunk header.
+	Offset int
+	// The number of the context lines ignored to apply.
+	Fuzz int
+	// True if the hunk matched only with ignoring whitespace differences.
+	IgnoredWhitespace bool
+	// The reason of the failure.
+	Error string
+	// The actual text around the place where the failed hunk is expected,
+	// prefixed by the line numbers.
+	Actual string
+}
+
+// String implements Stringer interface.
+func (r HunkResult) String() string {
+	if !r.Applied {
+		return fmt.Sprintf("hunk #%d failed: %s\nactual text around line %d:\n%s", r.Index+1, r.Error, r.Line, r.Actual)
+	}
+	var notes []string
+	if r.Offset != 0 {
+		notes = append(notes, fmt.Sprintf("offset %d lines", r.Offset))
+	}
+	if r.Fuzz > 0 {
+		notes = append(notes, fmt.Sprintf("fuzz %d", r.Fuzz))
+	}
+	if r.IgnoredWhitespace {
+		notes = append(notes, "ignoring whitespace")
+	}
+	msg := fmt.Sprintf("hunk #%d applied at line %d", r.Index+1, r.Line)
+	if len(notes) > 0 {
+		msg += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return msg
+}
+
+func splitLines(content string) ([]string, bool) {
+	if content == "" {
+		return nil, true
+	}
+	hasNewline := strings.HasSuffix(content, "\n")
+	content = strings.TrimSuffix(content, "\n")
+	return strings.Split(content, "\n"), hasNewline
+}
+
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func matchAt(lines []string, pos int, pattern []string, ignoreSpace bool) bool {
+	if pos < 0 || pos+len(pattern) > len(lines) {
+		return false
+	}
+	for i, p := range pattern {
+		l := lines[pos+i]
+		if ignoreSpace {
+			if normalizeSpace(l) != normalizeSpace(p) {
+				return false
+			}
+		} else if l != p {
+			return false
+		}
+	}
+	return true
+}
+
+// find looks for the pattern in lines[from:], preferring the place
+// nearest to the expected position.
+func find(lines []string, from, expected int, pattern []string, ignoreSpace bool) int {
+	expected = max(expected, from)
+	for d := 0; ; d++ {
+		before := expected - d
+		after := expected + d
+		if before < from && after+len(pattern) > len(lines) {
+			return -1
+		}
+		if after+len(pattern) <= len(lines) && matchAt(lines, after, pattern, ignoreSpace) {
+			return after
+		}
+		if d > 0 && before >= from && matchAt(lines, before, pattern, ignoreSpace) {
+			return before
+		}
+	}
+}
+
+// bestEffort returns the position where the pattern matches the most
+// lines, to show the actual text when a hunk fails.
+func bestEffort(lines []string, from, expected int, pattern []string) int {
+	best := min(max(expected, from), len(lines))
+	bestScore := 0
+	for pos := from; pos < len(lines); pos++ {
+		score := 0
+		for i, p := range pattern {
+			if pos+i < len(lines) && normalizeSpace(lines[pos+i]) == normalizeSpace(p) && p != "" {
+				score++
+			}
+		}
+		if score > bestScore {
+			best = pos
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func excerpt(lines []string, start, length int) string {
+	from := max(start-failureContextLines, 0)
+	to := min(start+length+failureContextLines, len(lines))
+	b := &strings.Builder{}
+	for i := from; i < to; i++ {
+		fmt.Fprintf(b, "%d: %s\n", i+1, lines[i])
+	}
+	return b.String()
+}
+
+// Apply applies the hunks to the content. It returns the new content and
+// the result of each hunk; the content is valid only when all the hunks
+// are applied.
+func Apply(content string, hunks []*Hunk) (string, []HunkResult) {
+	lines, hasNewline := splitLines(content)
+	var out []string
+	results := make([]HunkResult, 0, len(hunks))
+	// The position in lines copied to out so far.
+	pos := 0
+	// The drift of the previous hunk from its header, expected to be
+	// shared by the following hunks.
+	offset := 0
+	failed := false
+	for i, h := range hunks {
+		result := HunkResult{Index: i}
+		// The index in lines where the hunk starts according to the
+		// header. A hunk without old lines is inserted after the line
+		// in the header, as in `@@ -20,0 +21,1 @@`.
+		base := h.OldStart - 1
+		if len(h.oldLines()) == 0 {
+			base = h.OldStart
+		}
+		expected := pos
+		if h.OldStart > 0 {
+			expected = base + offset
+		}
+		hunkLines := h.Lines
+		at := -1
+		for fuzz := 0; fuzz <= maxFuzz && at < 0; fuzz++ {
+			trimmed, skipped, ok := trimContext(h.Lines, fuzz)
+			if !ok {
+				break
+			}
+			old := (&Hunk{Lines: trimmed}).oldLines()
+			if len(old) == 0 {
+				// Pure insertion; trust the line number.
+				at = min(max(expected+skipped, pos), len(lines))
+			} else if at = find(lines, pos, expected+skipped, old, false); at < 0 {
+				at = find(lines, pos, expected+skipped, old, true)
+				result.IgnoredWhitespace = at >= 0
+			}
+			if at >= 0 {
+				hunkLines = trimmed
+				result.Fuzz = fuzz
+				if h.OldStart > 0 {
+					result.Offset = at - (base + skipped)
+				}
+			}
+		}
+		if at < 0 {
+			old := h.oldLines()
+			near := bestEffort(lines, pos, expected, old)
+			result.Line = near + 1
+			result.Error = "the context and the removed lines don't match the file"
+			result.Actual = excerpt(lines, near, len(old))
+			results = append(results, result)
+			failed = true
+			continue
+		}
+		result.Applied = true
+		result.Line = at + 1
+
+		out = append(out, lines[pos:at]...)
+		cur := at
+		for _, l := range hunkLines {
+			switch l.Kind {
+			case LineContext:
+				// Keep the actual line in case whitespace is different.
+				out = append(out, lines[cur])
+				cur++
+			case LineDelete:
+				cur++
+			case LineAdd:
+				out = append(out, l.Text)
+			}
+		}
+		if h.OldStart > 0 {
+			offset = result.Offset
+		}
+		pos = cur
+		if pos == len(lines) && len(lines) > 0 {
+			// The hunk reaches the end; it decides the newline at the
+			// end of the file.
+			hasNewline = !h.NoNewlineAtEnd
+		}
+		results = append(results, result)
+	}
+	if failed {
+		return content, results
+	}
+	out = append(out, lines[pos:]...)
+	if len(out) == 0 {
+		return "", results
+	}
+	newContent := strings.Join(out, "\n")
+	if hasNewline {
+		newContent += "\n"
+	}
+	return newContent, results
+}
+
+// trimContext removes up to fuzz context lines from the beginning and
+// the end of the hunk, and returns the number of the lines removed from
+// the beginning. It returns false if there are no context lines to trim,
+// or if no lines would be left to locate the hunk.
+func trimContext(lines []Line, fuzz int) ([]Line, int, bool) {
+	if fuzz == 0 {
+		return lines, 0, true
+	}
+	start := 0
+	for start < fuzz && start < len(lines) && lines[start].Kind == LineContext {
+		start++
+	}
+	end := len(lines)
+	for len(lines)-end < fuzz && end > start && lines[end-1].Kind == LineContext {
+		end--
+	}
+	if start == 0 && end == len(lines) {
+		return nil, 0, false
+	}
+	trimmed := lines[start:end]
+	for _, l := range trimmed {
+		if l.Kind != LineAdd {
+			return trimmed, start, true
+		}
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,162 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numbered returns the lines "line1" to "lineN" with the newlines.
+func numbered(n int) []string {
+	var lines []string
+	for i := 1; i <= n; i++ {
+		lines = append(lines, fmt.Sprintf("line%d\n", i))
+	}
+	return lines
+}
+
+// join concatenates the lines, replacing the ones at the indices.
+func join(lines []string, replace map[int]string) string {
+	b := &strings.Builder{}
+	for i, l := range lines {
+		if r, ok := replace[i]; ok {
+			b.WriteString(r)
+		} else {
+			b.WriteString(l)
+		}
+	}
+	return b.String()
+}
+
+func TestApply(t *testing.T) {
+	lines := numbered(30)
+	for _, tc := range []struct {
+		name    string
+		content string
+		diff    string
+		want    string
+		// The expected lines where the hunks are applied; 0 for the
+		// failed hunks.
+		wantLines []int
+	}{
+		{
+			name:      "simple",
+			content:   "a\nb\nc\n",
+			diff:      "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+			want:      "a\nB\nc\n",
+			wantLines: []int{1},
+		},
+		{
+			name:      "offset",
+			content:   join(lines, nil),
+			diff:      "@@ -5,3 +5,3 @@\n line10\n-line11\n+LINE11\n line12\n",
+			want:      join(lines, map[int]string{10: "LINE11\n"}),
+			wantLines: []int{10},
+		},
+		{
+			name:    "multiple hunks",
+			content: join(lines, nil),
+			diff: "@@ -2,3 +2,8 @@\n line2\n+a\n+b\n+c\n+d\n+e\n line3\n line4\n" +
+				"@@ -20,3 +25,3 @@\n line20\n-line21\n+LINE21\n line22\n",
+			want:      join(lines, map[int]string{1: "line2\na\nb\nc\nd\ne\n", 20: "LINE21\n"}),
+			wantLines: []int{2, 20},
+		},
+		{
+			name:    "insertion after an earlier hunk",
+			content: join(lines, nil),
+			diff: "@@ -2,3 +2,8 @@\n line2\n+a\n+b\n+c\n+d\n+e\n line3\n line4\n" +
+				"@@ -20,0 +26,1 @@\n+INS\n",
+			want:      join(lines, map[int]string{1: "line2\na\nb\nc\nd\ne\n", 19: "line20\nINS\n"}),
+			wantLines: []int{2, 21},
+		},
+		{
+			name:    "drift shared by the later hunks",
+			content: join(lines, nil),
+			diff: "@@ -1,3 +1,3 @@\n line4\n-line5\n+LINE5\n line6\n" +
+				"@@ -20,0 +20,1 @@\n+INS\n",
+			want:      join(lines, map[int]string{4: "LINE5\n", 22: "line23\nINS\n"}),
+			wantLines: []int{4, 24},
+		},
+		{
+			name:      "fuzz",
+			content:   join(lines, nil),
+			diff:      "@@ -10,5 +10,5 @@\n foo\n line10\n-line11\n+LINE11\n line12\n bar\n",
+			want:      join(lines, map[int]string{10: "LINE11\n"}),
+			wantLines: []int{10},
+		},
+		{
+			name:      "fuzz doesn't drop all the context",
+			content:   join(lines, nil),
+			diff:      "@@ -12,4 +12,5 @@\n zzz\n yyy\n+new\n xxx\n www\n",
+			want:      join(lines, nil),
+			wantLines: []int{0},
+		},
+		{
+			name:      "mismatch",
+			content:   "a\nb\nc\n",
+			diff:      "@@ -1,3 +1,3 @@\n a\n-x\n+y\n c\n",
+			want:      "a\nb\nc\n",
+			wantLines: []int{0},
+		},
+		{
+			name:      "whitespace",
+			content:   "func() {\n\treturn 1\n}\n",
+			diff:      "@@ -1,3 +1,3 @@\n func() {\n-    return 1\n+\treturn 2\n }\n",
+			want:      "func() {\n\treturn 2\n}\n",
+			wantLines: []int{1},
+		},
+		{
+			name:      "remove the newline at the end",
+			content:   "a\nb\n",
+			diff:      "@@ -1,2 +1,2 @@\n a\n-b\n+c\n\\ No newline at end of file\n",
+			want:      "a\nc",
+			wantLines: []int{1},
+		},
+		{
+			name:      "add the newline at the end",
+			content:   "a\nb",
+			diff:      "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n",
+			want:      "a\nc\n",
+			wantLines: []int{1},
+		},
+		{
+			name:      "keep no newline at the end",
+			content:   "a\nb",
+			diff:      "@@ -1,2 +1,2 @@\n-a\n+c\n b\n\\ No newline at end of file\n",
+			want:      "c\nb",
+			wantLines: []int{1},
+		},
+		{
+			name:      "no newline untouched",
+			content:   "a\nb\nc\nd\ne\nf",
+			diff:      "@@ -1,2 +1,2 @@\n-a\n+A\n b\n",
+			want:      "A\nb\nc\nd\ne\nf",
+			wantLines: []int{1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			diffs, err := Parse(tc.diff, "file.txt")
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			if len(diffs) != 1 {
+				t.Fatalf("got %d file diffs, want 1", len(diffs))
+			}
+			got, results := Apply(tc.content, diffs[0].Hunks)
+			if got != tc.want {
+				t.Errorf("Apply() = %q, want %q", got, tc.want)
+			}
+			var gotLines []int
+			for _, r := range results {
+				if r.Applied {
+					gotLines = append(gotLines, r.Line)
+				} else {
+					gotLines = append(gotLines, 0)
+				}
+			}
+			if fmt.Sprint(gotLines) != fmt.Sprint(tc.wantLines) {
+				t.Errorf("applied at %v, want %v: %v", gotLines, tc.wantLines, results)
+			}
+		})
+	}
+}
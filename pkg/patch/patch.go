@@ -0,0 +1,167 @@
+// package patch parses unified diffs and applies them to the file contents.
+//
+// The patches are often written by LLMs, so the parser and the applier
+// are lenient: line numbers in the hunk headers are treated as hints,
+// hunks are located by their context, and whitespace differences or a
+// few unmatched context lines are tolerated.
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DevNull is the file name used for the missing side of a new or
+// deleted file.
+const DevNull = "/dev/null"
+
+// Line kinds in a hunk.
+const (
+	LineContext = ' '
+	LineDelete  = '-'
+	LineAdd     = '+'
+)
+
+// Line is a line in a hunk.
+type Line struct {
+	// Kind is one of LineContext, LineDelete, or LineAdd.
+	Kind byte
+	// Text is the content of the line without the newline.
+	Text string
+}
+
+// Hunk is a set of the changes in a contiguous region of a file.
+type Hunk struct {
+	// The line number (1-based) in the original file, or 0 if unknown.
+	OldStart int
+	// The line number (1-based) in the new file, or 0 if unknown.
+	NewStart int
+	// The lines of the hunk.
+	Lines []Line
+	// True if the new content has no newline at the end of the file.
+	NoNewlineAtEnd bool
+}
+
+func (h *Hunk) oldLines() []string {
+	var lines []string
+	for _, l := range h.Lines {
+		if l.Kind != LineAdd {
+			lines = append(lines, l.Text)
+		}
+	}
+	return lines
+}
+
+// FileDiff is the set of the hunks for a file.
+type FileDiff struct {
+	// The name of the original file; DevNull for a new file.
+	OldName string
+	// The name of the new file; DevNull for a deleted file.
+	NewName string
+	// The hunks in the order of the appearance.
+	Hunks []*Hunk
+}
+
+// IsNew returns true if the diff creates a new file.
+func (fd *FileDiff) IsNew() bool {
+	return fd.OldName == DevNull
+}
+
+// IsDelete returns true if the diff deletes the file.
+func (fd *FileDiff) IsDelete() bool {
+	return fd.NewName == DevNull
+}
+
+// Path returns the path of the file the diff is about.
+func (fd *FileDiff) Path() string {
+	if fd.IsDelete() {
+		return fd.OldName
+	}
+	return fd.NewName
+}
+
+var hunkHeader = regexp.MustCompile(`^@@+\s*(?:-(\d+)(?:,\d+)?\s+\+(\d+)(?:,\d+)?)?.*$`)
+
+func parseFileName(s string) string {
+	// Strip timestamps separated by a tab, as in `--- a/foo.txt\t2025-01-01`.
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if s == DevNull {
+		return s
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+// Parse parses the text of a unified diff which may contain multiple
+// files. The filename is used for the hunks which appear before any file
+// headers; it can be empty if the diff always has them.
+func Parse(text, filename string) ([]*FileDiff, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var results []*FileDiff
+	var current *FileDiff
+	var hunk *Hunk
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		switch {
+		case strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			current = &FileDiff{
+				OldName: parseFileName(l[4:]),
+				NewName: parseFileName(lines[i+1][4:]),
+			}
+			results = append(results, current)
+			hunk = nil
+			i++
+		case strings.HasPrefix(l, "@@"):
+			if current == nil {
+				if filename == "" {
+					return nil, fmt.Errorf("line %d: hunk without the file header", i+1)
+				}
+				current = &FileDiff{OldName: filename, NewName: filename}
+				results = append(results, current)
+			}
+			hunk = &Hunk{}
+			if m := hunkHeader.FindStringSubmatch(l); m != nil && m[1] != "" {
+				hunk.OldStart, _ = strconv.Atoi(m[1])
+				hunk.NewStart, _ = strconv.Atoi(m[2])
+			}
+			current.Hunks = append(current.Hunks, hunk)
+		case hunk == nil:
+			// Preambles like `diff --git` or `index` lines.
+			continue
+		case strings.HasPrefix(l, `\`):
+			// "\ No newline at end of file" applies to the previous line.
+			if n := len(hunk.Lines); n > 0 && hunk.Lines[n-1].Kind != LineDelete {
+				hunk.NoNewlineAtEnd = true
+			}
+		case l == "":
+			// Some editors strip the trailing space of empty context lines.
+			hunk.Lines = append(hunk.Lines, Line{Kind: LineContext})
+		case l[0] == LineContext || l[0] == LineDelete || l[0] == LineAdd:
+			hunk.Lines = append(hunk.Lines, Line{Kind: l[0], Text: l[1:]})
+		case strings.HasPrefix(l, "diff ") || strings.HasPrefix(l, "index "):
+			hunk = nil
+		default:
+			return nil, fmt.Errorf("line %d: unexpected line in a hunk: %q", i+1, l)
+		}
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no file diffs found")
+	}
+	for _, fd := range results {
+		if len(fd.Hunks) == 0 && !fd.IsDelete() {
+			return nil, fmt.Errorf("no hunks for %s", fd.Path())
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,292 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	checkpointsFile = "checkpoints.jsonl"
+	checkpointsDir  = "checkpoints"
+)
+
+// FileSnapshot is the state of a file right before a tool modifies it.
+type FileSnapshot struct {
+	// The path of the file relative to the project root.
+	Path string `json:"path"`
+	// Whether the file existed before the modification.
+	Existed bool `json:"existed"`
+	// True if the path was a directory.
+	IsDir bool `json:"is_dir,omitempty"`
+	// The permission bits of the file.
+	Mode fs.FileMode `json:"mode,omitempty"`
+	// The name of the blob keeping the previous content, relative to
+	// the checkpoints directory.
+	Blob string `json:"blob,omitempty"`
+}
+
+// Checkpoint is the set of the files touched by a single tool call.
+type Checkpoint struct {
+	// The sequential ID of the checkpoint in the session.
+	ID int `json:"id"`
+	// The turn (the user message) in which the tool was called.
+	Turn int `json:"turn"`
+	// The name of the tool which modified the files.
+	ToolName string `json:"tool_name"`
+	// The time when the first file was recorded.
+	Timestamp time.Time `json:"timestamp"`
+	// The snapshots of the files in the order of the modification.
+	Files []FileSnapshot `json:"files"`
+}
+
+func (s *Session) checkpointsPath() string {
+	return filepath.Join(s.sessionPath, checkpointsDir)
+}
+
+func (s *Session) loadCheckpoints() error {
+	if s.checkpointsLoaded {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(s.sessionPath, checkpointsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.checkpointsLoaded = true
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		cp := &Checkpoint{}
+		if err := json.Unmarshal(sc.Bytes(), cp); err != nil {
+			return err
+		}
+		s.checkpoints = append(s.checkpoints, cp)
+		s.turn = max(s.turn, cp.Turn)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	s.checkpointsLoaded = true
+	return nil
+}
+
+func (s *Session) saveCheckpoints() error {
+	if err := os.MkdirAll(s.sessionPath, 0755); err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, cp := range s.checkpoints {
+		if err := enc.Encode(cp); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(s.sessionPath, checkpointsFile), buf.Bytes(), 0600)
+}
+
+// StartTurn marks the beginning of a new user message. Checkpoints
+// created afterwards belong to the new turn.
+func (s *Session) StartTurn() error {
+	if err := s.loadCheckpoints(); err != nil {
+		return err
+	}
+	s.turn++
+	s.pending = nil
+	return nil
+}
+
+// StartCheckpoint starts a new checkpoint for a tool call. The checkpoint
+// is recorded only when a file is actually snapshotted.
+func (s *Session) StartCheckpoint(toolName string) {
+	s.pending = &Checkpoint{
+		Turn:     s.turn,
+		ToolName: toolName,
+	}
+}
+
+// SnapshotFile keeps the current state of the file in the session before
+// it gets modified, so that it can be restored by RestoreCheckpoint.
+// Directories are recorded recursively.
+func (s *Session) SnapshotFile(root *os.Root, name string) error {
+	if err := s.loadCheckpoints(); err != nil {
+		return err
+	}
+	if s.pending == nil {
+		s.StartCheckpoint("")
+	}
+	cp := s.pending
+	if cp.ID == 0 {
+		cp.ID = 1
+		if len(s.checkpoints) > 0 {
+			cp.ID = s.checkpoints[len(s.checkpoints)-1].ID + 1
+		}
+		cp.Timestamp = time.Now()
+	}
+	name = filepath.Clean(name)
+	for _, f := range cp.Files {
+		if f.Path == name {
+			return nil
+		}
+	}
+
+	snapshots, err := s.snapshot(root, cp, name)
+	if err != nil {
+		return err
+	}
+	if len(cp.Files) == 0 {
+		s.checkpoints = append(s.checkpoints, cp)
+	}
+	cp.Files = append(cp.Files, snapshots...)
+	return s.saveCheckpoints()
+}
+
+func (s *Session) snapshot(root *os.Root, cp *Checkpoint, name string) ([]FileSnapshot, error) {
+	stat, err := root.Lstat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []FileSnapshot{{Path: name}}, nil
+		}
+		return nil, err
+	}
+	if !stat.IsDir() {
+		snapshot, err := s.snapshotFile(root, cp, name, len(cp.Files), stat.Mode())
+		if err != nil {
+			return nil, err
+		}
+		return []FileSnapshot{snapshot}, nil
+	}
+	var snapshots []FileSnapshot
+	err = fs.WalkDir(root.FS(), name, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			snapshots = append(snapshots, FileSnapshot{
+				Path:    path,
+				Existed: true,
+				IsDir:   true,
+				Mode:    info.Mode().Perm(),
+			})
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			// Symlinks or devices are not restorable; skip.
+			return nil
+		}
+		snapshot, err := s.snapshotFile(root, cp, path, len(cp.Files)+len(snapshots), info.Mode())
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	return snapshots, err
+}
+
+func (s *Session) snapshotFile(root *os.Root, cp *Checkpoint, name string, index int, mode fs.FileMode) (FileSnapshot, error) {
+	data, err := root.ReadFile(name)
+	if err != nil {
+		return FileSnapshot{}, err
+	}
+	blob := filepath.Join(strconv.Itoa(cp.ID), strconv.Itoa(index))
+	p := filepath.Join(s.checkpointsPath(), blob)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return FileSnapshot{}, err
+	}
+	if err := os.WriteFile(p, data, 0600); err != nil {
+		return FileSnapshot{}, err
+	}
+	return FileSnapshot{
+		Path:    name,
+		Existed: true,
+		Mode:    mode.Perm(),
+		Blob:    blob,
+	}, nil
+}
+
+// Checkpoints returns the list of the checkpoints in the session, older first.
+func (s *Session) Checkpoints() ([]*Checkpoint, error) {
+	if err := s.loadCheckpoints(); err != nil {
+		return nil, err
+	}
+	return s.checkpoints, nil
+}
+
+// RestoreCheckpoint restores the files into the state right before the
+// checkpoint of the id, undoing the checkpoint and all later ones.
+// It returns the list of the restored paths.
+func (s *Session) RestoreCheckpoint(root *os.Root, id int) ([]string, error) {
+	if err := s.loadCheckpoints(); err != nil {
+		return nil, err
+	}
+	pos := -1
+	for i, cp := range s.checkpoints {
+		if cp.ID == id {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return nil, fmt.Errorf("checkpoint %d not found", id)
+	}
+	var restored []string
+	for i := len(s.checkpoints) - 1; i >= pos; i-- {
+		cp := s.checkpoints[i]
+		for j := len(cp.Files) - 1; j >= 0; j-- {
+			if err := s.restoreFile(root, cp.Files[j]); err != nil {
+				return restored, fmt.Errorf("failed to restore %s: %w", cp.Files[j].Path, err)
+			}
+			restored = append(restored, cp.Files[j].Path)
+		}
+		// The checkpoint is fully restored; drop it so that a failure in
+		// an older checkpoint doesn't restore this one twice.
+		s.checkpoints = s.checkpoints[:i]
+		if err := os.RemoveAll(filepath.Join(s.checkpointsPath(), strconv.Itoa(cp.ID))); err != nil {
+			return restored, err
+		}
+		if err := s.saveCheckpoints(); err != nil {
+			return restored, err
+		}
+	}
+	s.pending = nil
+	return restored, nil
+}
+
+func (s *Session) restoreFile(root *os.Root, f FileSnapshot) error {
+	if !f.Existed {
+		err := root.Remove(f.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if f.IsDir {
+		return root.MkdirAll(f.Path, f.Mode)
+	}
+	data, err := os.ReadFile(filepath.Join(s.checkpointsPath(), f.Blob))
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(f.Path); dir != "." {
+		if err := root.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return root.WriteFile(f.Path, data, f.Mode)
+}
@@ -0,0 +1,47 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+const outputsDir = "outputs"
+
+var outputIDPattern = regexp.MustCompile(`^[0-9a-f]+$`)
+
+func (s *Session) outputsPath() string {
+	return filepath.Join(s.logPath(), outputsDir)
+}
+
+// SaveOutput stores the full output of a command in the session log
+// directory, and returns the ID to read it later.
+func (s *Session) SaveOutput(output string) (string, error) {
+	if err := os.MkdirAll(s.outputsPath(), 0755); err != nil {
+		return "", err
+	}
+	id := uuid.New()
+	name := fmt.Sprintf("%x", id[:6])
+	if err := os.WriteFile(filepath.Join(s.outputsPath(), name+".txt"), []byte(output), 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// ReadOutput returns the output stored by SaveOutput.
+func (s *Session) ReadOutput(id string) (string, error) {
+	if !outputIDPattern.MatchString(id) {
+		return "", fmt.Errorf("malformed output ID %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(s.outputsPath(), id+".txt"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("output %s not found", id)
+		}
+		return "", err
+	}
+	return string(data), nil
+}
@@ -29,6 +29,17 @@ type sessionMeta struct {
 	SessionID  string    `toml:"session_id"`
 	Timestamp  time.Time `toml:"timestamp"`
 	WorkingDir string    `toml:"path"`
+	GitBase    string    `toml:"git_base,omitempty"`
+	// The untracked files when the session started.
+	GitUntracked []string `toml:"git_untracked,omitempty"`
+
+	// The title generated by the agent after the first turn.
+	Title       string `toml:"title,omitempty"`
+	FirstPrompt string `toml:"first_prompt,omitempty"`
+	// The number of the messages sent by the user.
+	Messages     int       `toml:"messages"`
+	Model        string    `toml:"model,omitempty"`
+	LastActivity time.Time `toml:"last_activity,omitempty"`
 }
 
 type logger struct {
@@ -59,6 +70,11 @@ type Session struct {
 	loggers map[string]*logger
 	files   map[string]*os.File
 
+	checkpoints       []*Checkpoint
+	checkpointsLoaded bool
+	pending           *Checkpoint
+	turn              int
+
 	initialized bool
 }
 
@@ -77,6 +93,12 @@ func FromContext(ctx context.Context) (*Session, bool) {
 	return s, ok
 }
 
+// WithoutSession returns a new context which doesn't carry the session,
+// e.g. to run an agent for an auxiliary task without touching the history.
+func WithoutSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey, nil)
+}
+
 // LoggerFromContext returns a new slog.Logger from the context.
 func LoggerFromContext(ctx context.Context, name string) (*slog.Logger, error) {
 	s, ok := FromContext(ctx)
@@ -96,6 +118,67 @@ func (s *Session) Timestamp() time.Time {
 	return s.meta.Timestamp
 }
 
+// Title returns the title of the session, or empty if no messages are
+// sent yet. It's derived from the first prompt until the title is
+// generated.
+func (s *Session) Title() string {
+	if s.meta.Title != "" {
+		return s.meta.Title
+	}
+	return titleFrom(s.meta.FirstPrompt)
+}
+
+// SetTitle records the title of the session, e.g. generated by the agent.
+func (s *Session) SetTitle(title string) error {
+	s.meta.Title = titleFrom(title)
+	if !s.initialized || s.meta.SessionID == "" {
+		return nil
+	}
+	return s.writeMeta()
+}
+
+// FirstPrompt returns the first message of the user in the session.
+func (s *Session) FirstPrompt() string {
+	return s.meta.FirstPrompt
+}
+
+// MessageCount returns the number of the messages sent by the user.
+func (s *Session) MessageCount() int {
+	return s.meta.Messages
+}
+
+// Model returns the backend and the model used last in the session.
+func (s *Session) Model() string {
+	return s.meta.Model
+}
+
+// LastActivity returns the time when the last message is sent, or the
+// time when the session started.
+func (s *Session) LastActivity() time.Time {
+	if s.meta.LastActivity.IsZero() {
+		return s.meta.Timestamp
+	}
+	return s.meta.LastActivity
+}
+
+// RecordMessage records the message of the user sent to the model in the
+// metadata, and the transcript for the search.
+func (s *Session) RecordMessage(prompt, model string) error {
+	if s.meta.FirstPrompt == "" {
+		s.meta.FirstPrompt = prompt
+	}
+	s.meta.Messages++
+	s.meta.Model = model
+	s.meta.LastActivity = time.Now()
+	if !s.initialized || s.meta.SessionID == "" {
+		return nil
+	}
+	if err := s.writeMeta(); err != nil {
+		return err
+	}
+	return s.AppendTranscript(RoleUser, prompt)
+}
+
 func (s *Session) updateSessionsFile(workingDir string) error {
 	sessionsFile := filepath.Join(workingDir, sessionIDsFile)
 	sessionsContent, err := os.ReadFile(sessionsFile)
@@ -148,16 +231,42 @@ func (s *Session) Init() error {
 		return err
 	}
 
+	if err := s.writeMeta(); err != nil {
+		return err
+	}
+	s.initialized = true
+	return nil
+}
+
+func (s *Session) writeMeta() error {
 	metaFile := filepath.Join(s.sessionPath, sessionMetaFile)
 	encodedMeta, err := toml.Marshal(s.meta)
 	if err != nil {
 		return err
 	}
-	if err := os.WriteFile(metaFile, encodedMeta, 0644); err != nil {
-		return err
+	return os.WriteFile(metaFile, encodedMeta, 0644)
+}
+
+// GitBase returns the git object name of the project state when the
+// session started, or an empty string if not recorded.
+func (s *Session) GitBase() string {
+	return s.meta.GitBase
+}
+
+// GitUntracked returns the untracked files when the session started.
+func (s *Session) GitUntracked() []string {
+	return s.meta.GitUntracked
+}
+
+// SetGitBase records the git object name of the project state and the
+// untracked files when the session started.
+func (s *Session) SetGitBase(base string, untracked []string) error {
+	s.meta.GitBase = base
+	s.meta.GitUntracked = untracked
+	if !s.initialized || s.meta.SessionID == "" {
+		return nil
 	}
-	s.initialized = true
-	return nil
+	return s.writeMeta()
 }
 
 // HistoryFile returns the path of the chat history in the session.
@@ -196,16 +305,26 @@ func (s *Session) GetLogFile(filename string) (io.Writer, error) {
 func (s *Session) LoadConfig() (*config.Config, error) {
 	var paths []string
 	if len(s.meta.WorkingDir) > 0 {
-		cacheDir, err := os.UserCacheDir()
+		p, err := s.ProjectConfigFile()
 		if err != nil {
 			return nil, err
 		}
-		paths = append(paths, config.ConfigFile(getWorkingDir(cacheDir, s.meta.WorkingDir)))
+		paths = append(paths, p)
 	}
 	paths = append(paths, s.sessionPath)
 	return config.LoadConfigFiles(paths...)
 }
 
+// ProjectConfigFile returns the path of the config file for the working
+// directory of the session, which is loaded after the user's config.
+func (s *Session) ProjectConfigFile() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return config.ConfigFile(getWorkingDir(cacheDir, s.meta.WorkingDir)), nil
+}
+
 // GetLogger returns a new logger stored in the session.
 func (s *Session) GetLogger(name string) (*slog.Logger, error) {
 	l, ok := s.loggers[name]
@@ -0,0 +1,209 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const transcriptFile = "transcript.jsonl"
+
+// The roles of the entries in the transcript.
+const (
+	RoleUser  = "user"
+	RoleModel = "model"
+)
+
+// The max length of the titles.
+const maxTitleLength = 60
+
+// The number of the characters around the match in the snippets.
+const snippetContext = 40
+
+// transcriptEntry is a message in the transcript. The transcript keeps the
+// texts of the conversation independently from the backends, some of
+// which don't store the history locally.
+type transcriptEntry struct {
+	Role      string    `json:"role"`
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Match is a message in the session matching the search.
+type Match struct {
+	// The role of the message; empty when it's found in the history of
+	// the backend.
+	Role string
+	// The part of the message around the match.
+	Snippet string
+}
+
+// titleFrom derives the title of the session from the first line of the
+// text.
+func titleFrom(text string) string {
+	var line string
+	for l := range strings.Lines(text) {
+		if line = strings.Join(strings.Fields(l), " "); line != "" {
+			break
+		}
+	}
+	if r := []rune(line); len(r) > maxTitleLength {
+		line = string(r[:maxTitleLength]) + "..."
+	}
+	return line
+}
+
+func (s *Session) transcriptPath() string {
+	return filepath.Join(s.sessionPath, transcriptFile)
+}
+
+// AppendTranscript adds the text of a message to the transcript.
+func (s *Session) AppendTranscript(role, text string) error {
+	if !s.initialized || s.meta.SessionID == "" || text == "" {
+		return nil
+	}
+	data, err := json.Marshal(&transcriptEntry{
+		Role:      role,
+		Text:      text,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.transcriptPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Search returns the messages in the session containing the text, ignoring
+// the cases. The sessions without the transcript are searched through the
+// strings in the history file of the backend.
+func (s *Session) Search(text string) ([]Match, error) {
+	if text == "" {
+		return nil, nil
+	}
+	var results []Match
+	add := func(role, t string) {
+		if snippet, ok := findSnippet(t, text); ok {
+			results = append(results, Match{Role: role, Snippet: snippet})
+		}
+	}
+
+	f, err := os.Open(s.transcriptPath())
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 16*1024*1024)
+		for scanner.Scan() {
+			var e transcriptEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				continue
+			}
+			add(e.Role, e.Text)
+		}
+		return results, scanner.Err()
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	add(RoleUser, s.meta.FirstPrompt)
+	h, err := os.Open(s.HistoryFile())
+	if os.IsNotExist(err) {
+		return results, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+	dec := json.NewDecoder(h)
+	for {
+		var v any
+		if err := dec.Decode(&v); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			// Not a JSON history; nothing to search.
+			break
+		}
+		walkStrings(v, func(t string) { add("", t) })
+	}
+	return results, nil
+}
+
+// walkStrings calls f for every string in the decoded JSON value.
+func walkStrings(v any, f func(string)) {
+	switch v := v.(type) {
+	case string:
+		f(v)
+	case []any:
+		for _, e := range v {
+			walkStrings(e, f)
+		}
+	case map[string]any:
+		for _, e := range v {
+			walkStrings(e, f)
+		}
+	}
+}
+
+// findSnippet returns the part of the text around the query, ignoring the
+// cases.
+func findSnippet(text, query string) (string, bool) {
+	runes := []rune(text)
+	q := []rune(query)
+	pos := indexFold(runes, q)
+	if pos < 0 {
+		return "", false
+	}
+	start := max(pos-snippetContext, 0)
+	end := min(pos+len(q)+snippetContext, len(runes))
+	snippet := strings.Join(strings.Fields(string(runes[start:end])), " ")
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(runes) {
+		snippet += "..."
+	}
+	return snippet, true
+}
+
+// indexFold returns the index of the first occurrence of the query in the
+// runes under the simple case folding, or -1 if not found.
+func indexFold(runes, query []rune) int {
+	for i := 0; i+len(query) <= len(runes); i++ {
+		matched := true
+		for j, r := range query {
+			if !equalFold(runes[i+j], r) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return i
+		}
+	}
+	return -1
+}
+
+// equalFold reports whether the runes are equal under the simple case
+// folding, as strings.EqualFold does.
+func equalFold(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/andreyvit/diff"
+	"github.com/jmuk/sylvan/pkg/patch"
+)
+
+type applyPatchRequest struct {
+	Patch    string `json:"patch" jsonschema:"required,description=the unified diff to apply. It may contain multiple files with the '--- a/path' and '+++ b/path' headers; use /dev/null for new or deleted files"`
+	Filename string `json:"filename,omitempty" jsonschema:"description=the file to apply the hunks to when the patch has no file headers"`
+}
+
+type appliedFile struct {
+	Path  string   `json:"path" jsonschema:"description=the path of the changed file"`
+	Hunks []string `json:"hunks" jsonschema:"description=how each hunk is applied"`
+}
+
+type applyPatchResponse struct {
+	Files []appliedFile `json:"files"`
+}
+
+// patchedFile is the result of applying a file diff in memory.
+type patchedFile struct {
+	fd         *patch.FileDiff
+	oldContent string
+	// The permission of the original file.
+	oldMode    os.FileMode
+	newContent string
+	results    []patch.HunkResult
+}
+
+// isRename returns true if the diff moves the file to another path.
+func isRename(fd *patch.FileDiff) bool {
+	return !fd.IsNew() && !fd.IsDelete() && filepath.Clean(fd.OldName) != filepath.Clean(fd.NewName)
+}
+
+func (ft *FileTools) patchFile(root *os.Root, fd *patch.FileDiff) (*patchedFile, error) {
+	pf := &patchedFile{fd: fd}
+	if !fd.IsNew() {
+		stat, err := root.Stat(fd.OldName)
+		if err != nil {
+			return nil, err
+		}
+		pf.oldMode = stat.Mode().Perm()
+		data, err := root.ReadFile(fd.OldName)
+		if err != nil {
+			return nil, err
+		}
+		pf.oldContent = string(data)
+	}
+	if fd.IsNew() || isRename(fd) {
+		if _, err := root.Stat(fd.NewName); err == nil {
+			return nil, fmt.Errorf("%s already exists", fd.NewName)
+		}
+	}
+	pf.newContent, pf.results = patch.Apply(pf.oldContent, fd.Hunks)
+	applied := !slices.ContainsFunc(pf.results, func(r patch.HunkResult) bool { return !r.Applied })
+	if fd.IsDelete() && applied && pf.newContent != "" {
+		// The removed lines need to cover the whole current content, so
+		// that a stale patch doesn't delete the unseen changes.
+		return nil, errors.New("the deletion doesn't match the current content of the file")
+	}
+	return pf, nil
+}
+
+func (ft *FileTools) applyPatch(ctx context.Context, req applyPatchRequest) (*applyPatchResponse, error) {
+	logger := getLogger(ctx)
+	logger.Info("Apply patch")
+	fds, err := patch.Parse(req.Patch, req.Filename)
+	if err != nil {
+		logger.Error("Failed to parse the patch", "error", err)
+		return nil, &ToolError{err}
+	}
+	root, err := ft.getRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply all the hunks in memory first, so that nothing is written
+	// unless the whole patch applies.
+	var patched []*patchedFile
+	var failures []string
+	seen := map[string]bool{}
+	for _, fd := range fds {
+		names := []string{filepath.Clean(fd.OldName), filepath.Clean(fd.NewName)}
+		if names[0] == names[1] {
+			names = names[:1]
+		}
+		var dup bool
+		for _, name := range names {
+			if name != patch.DevNull {
+				dup = dup || seen[name]
+				seen[name] = true
+			}
+		}
+		if dup {
+			// Each diff is applied to the original content, so the later
+			// one would overwrite the earlier one.
+			failures = append(failures, fmt.Sprintf("%s: the file appears more than once in the patch", fd.Path()))
+			continue
+		}
+		pf, err := ft.patchFile(root, fd)
+		if err != nil {
+			logger.Error("Failed to read the file", "filename", fd.Path(), "error", err)
+			failures = append(failures, fmt.Sprintf("%s: %v", fd.Path(), err))
+			continue
+		}
+		for _, r := range pf.results {
+			if !r.Applied {
+				failures = append(failures, fmt.Sprintf("%s: %s", fd.Path(), r))
+			}
+		}
+		patched = append(patched, pf)
+	}
+	if len(failures) > 0 {
+		logger.Error("Failed to apply the patch", "failures", failures)
+		return nil, &ToolError{fmt.Errorf("failed to apply the patch; nothing is changed:\n%s", strings.Join(failures, "\n"))}
+	}
+
+	for _, pf := range patched {
+		switch {
+		case pf.fd.IsDelete():
+			fmt.Printf("Deleting %s\n", pf.fd.OldName)
+		case pf.fd.IsNew():
+			fmt.Printf("Creating %s\n", pf.fd.NewName)
+		case pf.fd.OldName != pf.fd.NewName:
+			fmt.Printf("Renaming %s to %s\n", pf.fd.OldName, pf.fd.NewName)
+		default:
+			fmt.Printf("Modifying %s\n", pf.fd.NewName)
+		}
+		if !pf.fd.IsDelete() {
+			fmt.Println(diff.LineDiff(pf.oldContent, pf.newContent))
+		}
+	}
+	answer, err := confirmWith(ctx, false)
+	if err != nil {
+		logger.Error("Failed to confirm", "error", err)
+		return nil, err
+	}
+	if answer != confirmationYes {
+		logger.Error("User declined")
+		msg, err := declineReason(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &ToolError{fmt.Errorf("user declined to accept the patch: `%s`", msg)}
+	}
+
+	resp := &applyPatchResponse{}
+	for i, pf := range patched {
+		if err := ft.writePatchedFile(ctx, root, pf); err != nil {
+			logger.Error("Failed to write the file", "filename", pf.fd.Path(), "error", err)
+			// Revert the files written so far, including the failed one
+			// which may be written partially.
+			var revertErrs error
+			for j := i; j >= 0; j-- {
+				revertErrs = errors.Join(revertErrs, patched[j].revert(root))
+			}
+			if revertErrs != nil {
+				logger.Error("Failed to revert the files", "error", revertErrs)
+				return nil, &ToolError{errors.Join(fmt.Errorf("failed to write %s, and failed to revert the other files", pf.fd.Path()), err, revertErrs)}
+			}
+			return nil, &ToolError{errors.Join(fmt.Errorf("failed to write %s; nothing is changed", pf.fd.Path()), err)}
+		}
+		af := appliedFile{Path: pf.fd.Path()}
+		for _, r := range pf.results {
+			af.Hunks = append(af.Hunks, r.String())
+		}
+		resp.Files = append(resp.Files, af)
+	}
+	return resp, nil
+}
+
+func (ft *FileTools) writePatchedFile(ctx context.Context, root *os.Root, pf *patchedFile) error {
+	fd := pf.fd
+	if fd.IsDelete() || isRename(fd) {
+		if err := ft.snapshot(ctx, root, fd.OldName); err != nil {
+			return err
+		}
+		if err := root.Remove(fd.OldName); err != nil {
+			return err
+		}
+	}
+	if fd.IsDelete() {
+		return nil
+	}
+	if dir := filepath.Dir(fd.NewName); dir != "." {
+		// The new directories are recorded before the file, so that the
+		// file is removed first on undo.
+		for _, d := range missingDirs(root, dir) {
+			if err := ft.snapshot(ctx, root, d); err != nil {
+				return err
+			}
+		}
+		if err := root.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := ft.snapshot(ctx, root, fd.NewName); err != nil {
+		return err
+	}
+	return root.WriteFile(fd.NewName, []byte(pf.newContent), 0644)
+}
+
+// revert restores the state before writePatchedFile, when writing a later
+// file fails.
+func (pf *patchedFile) revert(root *os.Root) error {
+	fd := pf.fd
+	var errs error
+	if fd.IsNew() || isRename(fd) {
+		if _, err := root.Lstat(fd.NewName); err == nil {
+			errs = errors.Join(errs, root.Remove(fd.NewName))
+		}
+	}
+	if !fd.IsNew() {
+		errs = errors.Join(errs, root.WriteFile(fd.OldName, []byte(pf.oldContent), pf.oldMode))
+	}
+	return errs
+}
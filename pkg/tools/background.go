@@ -0,0 +1,268 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The max size of the unread output kept for each stream of a job.
+const maxJobOutput = 1 << 20
+
+// jobOutput keeps the output of a background job which is not read yet.
+type jobOutput struct {
+	mu      *sync.Mutex
+	data    []byte
+	dropped int
+}
+
+// Write implements io.Writer.
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.data = append(o.data, p...)
+	if over := len(o.data) - maxJobOutput; over > 0 {
+		o.data = o.data[over:]
+		o.dropped += over
+	}
+	return len(p), nil
+}
+
+// readNew returns the output since the last read.
+func (o *jobOutput) readNew() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := string(o.data)
+	if o.dropped > 0 {
+		s = fmt.Sprintf("(%d bytes dropped)\n", o.dropped) + s
+	}
+	o.data = nil
+	o.dropped = 0
+	return s
+}
+
+// backgroundJob is a command running in the background.
+type backgroundJob struct {
+	id          int
+	commandLine string
+	cmd         *exec.Cmd
+	startedAt   time.Time
+
+	mu       sync.Mutex
+	stdout   jobOutput
+	stderr   jobOutput
+	done     chan struct{}
+	exitCode int
+}
+
+func (j *backgroundJob) running() bool {
+	select {
+	case <-j.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (j *backgroundJob) status() backgroundJobStatus {
+	st := backgroundJobStatus{
+		JobID:       j.id,
+		CommandLine: j.commandLine,
+		StartedAt:   j.startedAt.Format(time.RFC3339),
+		Running:     j.running(),
+	}
+	if !st.Running {
+		code := j.exitCode
+		st.ExitCode = &code
+	}
+	return st
+}
+
+// stop kills the job's process group. The group is killed even after the
+// command itself exits, since its children (e.g. `server &`) may remain.
+func (j *backgroundJob) stop() {
+	running := j.running()
+	killProcessGroup(j.cmd.Process)
+	if running {
+		<-j.done
+	}
+}
+
+type backgroundJobStatus struct {
+	JobID       int    `json:"job_id" jsonschema:"description=the ID of the job"`
+	CommandLine string `json:"command_line" jsonschema:"description=the command line of the job"`
+	StartedAt   string `json:"started_at" jsonschema:"description=the time when the job started"`
+	Running     bool   `json:"running" jsonschema:"description=true if the job is still running"`
+	ExitCode    *int   `json:"exit_code,omitempty" jsonschema:"description=the exit code of the job when it's finished"`
+}
+
+type startBackgroundRequest struct {
+	CommandLine string            `json:"command_line" jsonschema:"required,description=the full command line string. This will be executed through shell in the project directory"`
+	WorkingDir  string            `json:"working_dir,omitempty" jsonschema:"description=the directory to run the command in, relative to the project root. It must be inside the project"`
+	Env         map[string]string `json:"env,omitempty" jsonschema:"description=the environment variables added for this command"`
+}
+
+type jobRequest struct {
+	JobID int `json:"job_id" jsonschema:"required,description=the ID of the job"`
+}
+
+type listBackgroundRequest struct{}
+
+type listBackgroundResponse struct {
+	Jobs []backgroundJobStatus `json:"jobs" jsonschema:"description=the background jobs"`
+}
+
+type backgroundOutputResponse struct {
+	backgroundJobStatus
+	Output   string `json:"output" jsonschema:"description=the standard output since the last read"`
+	ErrorOut string `json:"error_out" jsonschema:"description=the standard error output since the last read"`
+}
+
+func (et *ExecTool) getJob(id int) (*backgroundJob, error) {
+	et.jobsMu.Lock()
+	defer et.jobsMu.Unlock()
+	job, ok := et.jobs[id]
+	if !ok {
+		return nil, &ToolError{fmt.Errorf("job %d not found", id)}
+	}
+	return job, nil
+}
+
+func (et *ExecTool) startBackground(ctx context.Context, req startBackgroundRequest) (*backgroundJobStatus, error) {
+	logger := getLogger(ctx).With("commandline", req.CommandLine)
+	logger.Debug("Start background execution")
+	opts, err := et.newCommandOptions(req.WorkingDir, req.Env)
+	if err != nil {
+		logger.Error("Invalid options", "error", err)
+		return nil, err
+	}
+	commandLine, err := et.confirmCommand(ctx, logger, req.CommandLine, opts.details()...)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := et.command(context.Background(), opts, et.config.ShellPath(), "-c", commandLine)
+	if err != nil {
+		logger.Error("Failed to prepare the command", "error", err)
+		return nil, err
+	}
+	job := &backgroundJob{
+		commandLine: commandLine,
+		cmd:         cmd,
+		done:        make(chan struct{}),
+	}
+	job.stdout.mu = &job.mu
+	job.stderr.mu = &job.mu
+	cmd.Stdout = &job.stdout
+	cmd.Stderr = &job.stderr
+	// Don't wait for the grandchildren holding the output (e.g. by setsid
+	// or nohup) after the shell exits or the process group is killed.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		logger.Error("Failed to start", "error", err)
+		return nil, &ToolError{err}
+	}
+	job.startedAt = time.Now()
+	go func() {
+		cmd.Wait()
+		job.exitCode = cmd.ProcessState.ExitCode()
+		close(job.done)
+	}()
+
+	et.jobsMu.Lock()
+	et.nextJobID++
+	job.id = et.nextJobID
+	if et.jobs == nil {
+		et.jobs = map[int]*backgroundJob{}
+	}
+	et.jobs[job.id] = job
+	et.jobsMu.Unlock()
+
+	fmt.Printf("Started job %d\n", job.id)
+	logger.Info("Started", "job_id", job.id, "pid", cmd.Process.Pid)
+	st := job.status()
+	return &st, nil
+}
+
+func (et *ExecTool) listBackground(ctx context.Context, req listBackgroundRequest) (*listBackgroundResponse, error) {
+	et.jobsMu.Lock()
+	defer et.jobsMu.Unlock()
+	resp := &listBackgroundResponse{Jobs: []backgroundJobStatus{}}
+	for _, job := range et.jobs {
+		resp.Jobs = append(resp.Jobs, job.status())
+	}
+	sort.Slice(resp.Jobs, func(i, j int) bool {
+		return resp.Jobs[i].JobID < resp.Jobs[j].JobID
+	})
+	return resp, nil
+}
+
+func (et *ExecTool) readBackground(ctx context.Context, req jobRequest) (*backgroundOutputResponse, error) {
+	job, err := et.getJob(req.JobID)
+	if err != nil {
+		return nil, err
+	}
+	// Take the status first, so that the output is complete when it's
+	// reported as finished.
+	st := job.status()
+	return &backgroundOutputResponse{
+		backgroundJobStatus: st,
+		Output:              capOutput(ctx, job.stdout.readNew()),
+		ErrorOut:            capOutput(ctx, job.stderr.readNew()),
+	}, nil
+}
+
+func (et *ExecTool) stopBackground(ctx context.Context, req jobRequest) (*backgroundOutputResponse, error) {
+	job, err := et.getJob(req.JobID)
+	if err != nil {
+		return nil, err
+	}
+	getLogger(ctx).Info("Stopping", "job_id", job.id)
+	fmt.Printf("Stopping job %d\n", job.id)
+	job.stop()
+	et.jobsMu.Lock()
+	delete(et.jobs, job.id)
+	et.jobsMu.Unlock()
+	return &backgroundOutputResponse{
+		backgroundJobStatus: job.status(),
+		Output:              capOutput(ctx, job.stdout.readNew()),
+		ErrorOut:            capOutput(ctx, job.stderr.readNew()),
+	}, nil
+}
+
+// stopAllJobs stops all the background jobs.
+func (et *ExecTool) stopAllJobs() {
+	et.jobsMu.Lock()
+	defer et.jobsMu.Unlock()
+	for id, job := range et.jobs {
+		job.stop()
+		delete(et.jobs, id)
+	}
+}
+
+func (et *ExecTool) backgroundToolDefs() []ToolDefinition {
+	return []ToolDefinition{
+		&toolDefinition[startBackgroundRequest, *backgroundJobStatus]{
+			name:        "start_background_command",
+			description: "start a long-running command such as a dev server or a watcher in the background, and return the job ID. Use read_background_output to see its output",
+			proc:        et.startBackground,
+		},
+		&toolDefinition[listBackgroundRequest, *listBackgroundResponse]{
+			name:        "list_background_commands",
+			description: "list the background jobs",
+			proc:        et.listBackground,
+		},
+		&toolDefinition[jobRequest, *backgroundOutputResponse]{
+			name:        "read_background_output",
+			description: "read the output of a background job since the last read",
+			proc:        et.readBackground,
+		},
+		&toolDefinition[jobRequest, *backgroundOutputResponse]{
+			name:        "stop_background_command",
+			description: "stop a background job and return its remaining output",
+			proc:        et.stopBackground,
+		},
+	}
+}
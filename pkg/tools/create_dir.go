@@ -3,8 +3,9 @@ package tools
 import (
 	"context"
 	"fmt"
-
-	"github.com/manifoldco/promptui"
+	"os"
+	"path/filepath"
+	"slices"
 )
 
 type createDirRequest struct {
@@ -19,14 +20,14 @@ func (ft *FileTools) createDir(ctx context.Context, req createDirRequest) (*crea
 	logger.Info("Creating a new directory")
 	fmt.Printf("Creating a directory %s\n", req.Dirname)
 
-	answer, err := confirmWith(false)
+	answer, err := confirmWith(ctx, false)
 	if err != nil {
 		logger.Error("Failed to get the answer", "error", err)
 		return nil, err
 	}
 	if answer != confirmationYes {
 		logger.Error("User declined to create the directory")
-		msg, err := (&promptui.Prompt{Label: "Tell me why"}).Run()
+		msg, err := declineReason(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -37,6 +38,14 @@ func (ft *FileTools) createDir(ctx context.Context, req createDirRequest) (*crea
 	if err != nil {
 		return nil, err
 	}
+	// Only the new directories need to be recorded; the existing ones are
+	// left as is by MkdirAll. The parents are recorded before the children,
+	// so that the children are removed first on undo.
+	for _, dir := range missingDirs(root, req.Dirname) {
+		if err := ft.snapshot(ctx, root, dir); err != nil {
+			return nil, err
+		}
+	}
 	if err := root.MkdirAll(req.Dirname, 0755); err != nil {
 		logger.Error("Failed to create the directory", "error", err)
 		fmt.Println("Failed to create the directory:", err)
@@ -44,3 +53,17 @@ func (ft *FileTools) createDir(ctx context.Context, req createDirRequest) (*crea
 	}
 	return &createDirResponse{}, nil
 }
+
+// missingDirs returns the directories which MkdirAll creates for the name,
+// from the first missing ancestor down to the name itself.
+func missingDirs(root *os.Root, name string) []string {
+	var dirs []string
+	for dir := filepath.Clean(name); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if _, err := root.Stat(dir); !os.IsNotExist(err) {
+			break
+		}
+		dirs = append(dirs, dir)
+	}
+	slices.Reverse(dirs)
+	return dirs
+}
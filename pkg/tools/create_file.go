@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-
-	"github.com/manifoldco/promptui"
 )
 
 type writeFileRequest struct {
@@ -22,7 +20,7 @@ func (ft *FileTools) writeFile(ctx context.Context, req writeFileRequest) (newCo
 	fmt.Printf("Creating a new file %s with the following content...\n", filename)
 	fmt.Println("---\n", content)
 
-	result, err := confirm()
+	result, err := confirm(ctx)
 	if err != nil {
 		logger.Error("Failed to confirm", "error", err)
 		return "", err
@@ -35,7 +33,7 @@ func (ft *FileTools) writeFile(ctx context.Context, req writeFileRequest) (newCo
 		}
 	} else if result != confirmationYes {
 		logger.Info("User rejected to add the file")
-		msg, err := (&promptui.Prompt{Label: "Tell me why"}).Run()
+		msg, err := declineReason(ctx)
 		if err != nil {
 			return "", err
 		}
@@ -50,6 +48,11 @@ func (ft *FileTools) writeFile(ctx context.Context, req writeFileRequest) (newCo
 	if _, err := root.Stat(dirname); os.IsNotExist(err) {
 		fmt.Printf("Creating directory %s\n", dirname)
 		logger.Info("Creating directory", "dirname", dirname)
+		for _, dir := range missingDirs(root, dirname) {
+			if err := ft.snapshot(ctx, root, dir); err != nil {
+				return "", err
+			}
+		}
 		if err := root.MkdirAll(dirname, 0755); err != nil {
 			logger.Error("Failed to create directory", "dirname", dirname, "error", err)
 			return "", &ToolError{err}
@@ -59,6 +62,9 @@ func (ft *FileTools) writeFile(ctx context.Context, req writeFileRequest) (newCo
 		return "", &ToolError{err}
 	}
 
+	if err := ft.snapshot(ctx, root, filename); err != nil {
+		return "", err
+	}
 	err = root.WriteFile(filename, []byte(content), 0644)
 	if err != nil {
 		logger.Error("Failed to write", "error", err)
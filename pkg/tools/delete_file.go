@@ -3,8 +3,6 @@ package tools
 import (
 	"context"
 	"fmt"
-
-	"github.com/manifoldco/promptui"
 )
 
 type deleteFileRequest struct {
@@ -29,20 +27,24 @@ func (ft *FileTools) deleteFile(ctx context.Context, req deleteFileRequest) (*de
 	}
 
 	fmt.Println("Deleting the file", req.Filename)
-	answer, err := confirmWith(false)
+	answer, err := confirmWith(ctx, false)
 	if err != nil {
 		logger.Error("Failed to get the answer", "error", err)
 		return nil, err
 	}
 	if answer != confirmationYes {
 		logger.Error("User declined to delete the file")
-		msg, err := (&promptui.Prompt{Label: "Tell me why"}).Run()
+		msg, err := declineReason(ctx)
 		if err != nil {
 			return nil, err
 		}
 		return nil, &ToolError{fmt.Errorf("user declined to delete the file: `%s`", msg)}
 	}
 
+	if err := ft.snapshot(ctx, root, req.Filename); err != nil {
+		return nil, err
+	}
+
 	if !stat.IsDir() || !req.Recursive {
 		if err := root.Remove(req.Filename); err != nil {
 			logger.Error("Failed to delete the file", "error", err)
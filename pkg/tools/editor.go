@@ -8,6 +8,12 @@ import (
 	"path/filepath"
 )
 
+// EditText lets the user edit the content with their editor. The name is
+// used to pick the extension of the temporary file.
+func EditText(logger *slog.Logger, name, content string) (string, error) {
+	return userEdit(logger, name, content)
+}
+
 func userEdit(logger *slog.Logger, filename, content string) (string, error) {
 	editor := os.Getenv("VISUAL")
 	if editor == "" {
@@ -9,24 +9,106 @@ import (
 	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/jmuk/sylvan/pkg/config"
 	"github.com/manifoldco/promptui"
 )
 
 var commandDefaultTimeout = time.Minute
 
 type execCommandRequest struct {
-	CommandLine    string `json:"command_line" jsonschema:"the full command line string. This will be executed through shell"`
-	TimeoutSeconds int    `json:"timeout_seconds" jsonschema:"the timeout of the command execution in seconds. The default is 60 seconds"`
+	CommandLine    string            `json:"command_line" jsonschema:"the full command line string. This will be executed through shell"`
+	TimeoutSeconds int               `json:"timeout_seconds" jsonschema:"the timeout of the command execution in seconds. The default is 60 seconds"`
+	WorkingDir     string            `json:"working_dir,omitempty" jsonschema:"description=the directory to run the command in, relative to the project root. It must be inside the project"`
+	Env            map[string]string `json:"env,omitempty" jsonschema:"description=the environment variables added only for this command"`
+	Stdin          string            `json:"stdin,omitempty" jsonschema:"description=the text given to the standard input of the command"`
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// commandOptions is the settings specific to a command.
+type commandOptions struct {
+	// The absolute path of the working directory; the project directory
+	// if empty.
+	dir string
+	// The environment variables added to the command.
+	env map[string]string
+}
+
+// newCommandOptions validates the working directory and the environment
+// variables in the request.
+func (et *ExecTool) newCommandOptions(workingDir string, env map[string]string) (commandOptions, error) {
+	for name := range env {
+		if !envNamePattern.MatchString(name) {
+			return commandOptions{}, &ToolError{fmt.Errorf("invalid environment variable name %q", name)}
+		}
+	}
+	opts := commandOptions{env: env}
+	if workingDir == "" {
+		return opts, nil
+	}
+	base, err := filepath.Abs(et.dir)
+	if err != nil {
+		return opts, err
+	}
+	rel := workingDir
+	if filepath.IsAbs(rel) {
+		if rel, err = filepath.Rel(base, rel); err != nil {
+			return opts, &ToolError{err}
+		}
+	}
+	root, err := os.OpenRoot(base)
+	if err != nil {
+		return opts, err
+	}
+	defer root.Close()
+	// os.Root rejects the paths escaping from the project.
+	fi, err := root.Stat(rel)
+	if err != nil {
+		return opts, &ToolError{err}
+	}
+	if !fi.IsDir() {
+		return opts, &ToolError{fmt.Errorf("%s is not a directory", workingDir)}
+	}
+	opts.dir = filepath.Join(base, rel)
+	return opts, nil
+}
+
+// details returns the lines describing the options for the confirmation.
+func (opts commandOptions) details() []string {
+	var lines []string
+	if opts.dir != "" {
+		lines = append(lines, "Working directory: "+opts.dir)
+	}
+	for _, name := range sortedKeys(opts.env) {
+		lines = append(lines, fmt.Sprintf("Environment: %s=%s", name, opts.env[name]))
+	}
+	return lines
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 type execCommandResponse struct {
-	ReturnCode int    `json:"return_code" jsonschema:"description=the return code of the command; 0 means successful"`
+	ReturnCode int    `json:"return_code" jsonschema:"description=the return code of the command; 0 means successful, and -1 if it didn't exit by itself"`
+	Signal     string `json:"signal,omitempty" jsonschema:"description=the signal which terminated the command; with the persistent shell it's inferred from the return code above 128"`
+	TimedOut   bool   `json:"timed_out,omitempty" jsonschema:"description=true if the command is killed by the timeout"`
+	Canceled   bool   `json:"canceled,omitempty" jsonschema:"description=true if the user interrupted the command"`
 	Output     string `json:"output" jsonschema:"description=the standard output of the command"`
 	ErrorOut   string `json:"error_out" jsonschema:"description=the standard error output of the command"`
+	Note       string `json:"note,omitempty" jsonschema:"description=additional information about the execution"`
 }
 
 var whiteSpaces = regexp.MustCompile(`\s+`)
@@ -79,93 +161,242 @@ func (b *bufferWithViewer) String() string {
 
 // ExecTool is a manager of command execution tools.
 type ExecTool struct {
-}
+	dir    string
+	config config.ExecConfig
+	shell  *persistentShell
 
-// NewExecTool creates a new command execution tool.
-func NewExecTool() *ExecTool {
-	return &ExecTool{}
+	jobsMu    sync.Mutex
+	jobs      map[int]*backgroundJob
+	nextJobID int
 }
 
-func (et *ExecTool) execCommand(ctx context.Context, req execCommandRequest) (*execCommandResponse, error) {
-	logger := getLogger(ctx).With("commandline", req.CommandLine)
-	logger.Debug("Start execution")
-	commandLine := req.CommandLine
-	logger = logger.With("command", commandLine)
+// NewExecTool creates a new command execution tool running the commands
+// in the directory.
+func NewExecTool(dir string, c config.ExecConfig) *ExecTool {
+	et := &ExecTool{dir: dir, config: c}
+	et.shell = newPersistentShell(func() (*exec.Cmd, error) {
+		return et.command(context.Background(), commandOptions{}, c.ShellPath())
+	})
+	return et
+}
 
-	fmt.Println("Going to execute the following command:", commandLine)
-	answer, err := confirm()
+// confirmCommand asks the user whether to execute the command, and
+// returns the command line to be executed.
+func (et *ExecTool) confirmCommand(ctx context.Context, logger *slog.Logger, commandLine string, details ...string) (string, error) {
+	sandbox := et.config.Sandbox
+	if sandbox.Enabled && sandbox.AutoApprove {
+		fmt.Println("Executing the following command in the sandbox:", commandLine)
+		for _, d := range details {
+			fmt.Println(d)
+		}
+		return commandLine, nil
+	}
+	if sandbox.Enabled {
+		fmt.Println("Going to execute the following command in the sandbox:", commandLine)
+	} else {
+		fmt.Println("Going to execute the following command:", commandLine)
+	}
+	for _, d := range details {
+		fmt.Println(d)
+	}
+	answer, err := confirm(ctx)
 	if err != nil {
 		logger.Error("Failed to obtain the user answer", "error", err)
-		return nil, err
+		return "", err
 	}
 	if answer == confirmationEdit {
 		p := promptui.Prompt{
 			Label:   ">",
-			Default: req.CommandLine,
+			Default: commandLine,
 		}
 		commandLine, err = p.Run()
 		if err != nil {
 			logger.Error("Failed to obtain the user answer", "error", err)
-			return nil, err
+			return "", err
 		}
 	} else if answer != confirmationYes {
 		logger.Error("User declined to execute")
-		msg, err := (&promptui.Prompt{Label: "Tell me why"}).Run()
+		msg, err := declineReason(ctx)
+		if err != nil {
+			return "", err
+		}
+		return "", &ToolError{fmt.Errorf("user declied to execute: `%s`", msg)}
+	}
+	return commandLine, nil
+}
+
+// command creates the command with the arguments, in the sandbox if
+// it's enabled.
+func (et *ExecTool) command(ctx context.Context, opts commandOptions, args ...string) (*exec.Cmd, error) {
+	dir, err := filepath.Abs(et.dir)
+	if err != nil {
+		return nil, err
+	}
+	workDir := dir
+	if opts.dir != "" {
+		workDir = opts.dir
+	}
+	if et.config.Sandbox.Enabled {
+		args, err = sandboxCommand(et.config.Sandbox, dir, workDir, args)
 		if err != nil {
 			return nil, err
 		}
-		return nil, &ToolError{fmt.Errorf("user declied to execute: `%s`", msg)}
 	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = workDir
+	cmd.Env = et.config.Env.Filter(os.Environ())
+	for _, name := range sortedKeys(opts.env) {
+		cmd.Env = append(cmd.Env, name+"="+opts.env[name])
+	}
+	setProcessGroup(cmd)
+	return cmd, nil
+}
+
+func (et *ExecTool) execCommand(ctx context.Context, req execCommandRequest) (*execCommandResponse, error) {
+	logger := getLogger(ctx).With("commandline", req.CommandLine)
+	logger.Debug("Start execution")
+	opts, err := et.newCommandOptions(req.WorkingDir, req.Env)
+	if err != nil {
+		logger.Error("Invalid options", "error", err)
+		return nil, err
+	}
+	details := opts.details()
+	if req.Stdin != "" {
+		details = append(details, fmt.Sprintf("Stdin: %d bytes", len(req.Stdin)))
+	}
+	commandLine, err := et.confirmCommand(ctx, logger, req.CommandLine, details...)
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.With("command", commandLine)
 
 	timeout := time.Second * time.Duration(req.TimeoutSeconds)
 	if timeout == 0 {
 		timeout = commandDefaultTimeout
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, os.Getenv("SHELL"), "-c", commandLine)
 	stdout := newBufferWithViewer("", logger)
 	stderr := newBufferWithViewer("error:", logger)
 	defer stdout.Close()
 	defer stderr.Close()
+
+	resp := &execCommandResponse{}
+	if et.config.OneShot {
+		resp.ReturnCode, resp.Signal, err = et.runOneShot(runCtx, commandLine, opts, req.Stdin, stdout, stderr)
+	} else {
+		resp.ReturnCode, err = et.shell.run(runCtx, commandLine, opts, req.Stdin, stdout, stderr)
+		if errors.Is(err, errShellExited) {
+			resp.ReturnCode = -1
+			resp.Note = "the shell exited; a new shell starts with the next command"
+			err = nil
+		} else if err == nil {
+			// The shell only tells the status, which is 128+n when
+			// killed by the signal n.
+			resp.Signal = shellSignal(resp.ReturnCode)
+		}
+	}
+	if runErr := runCtx.Err(); runErr != nil {
+		resp.ReturnCode = -1
+		if errors.Is(runErr, context.DeadlineExceeded) {
+			resp.TimedOut = true
+			fmt.Printf("Timed out after %s\n", timeout)
+		} else {
+			resp.Canceled = true
+			fmt.Println("Interrupted")
+		}
+		if !et.config.OneShot {
+			resp.Note = "the shell is restarted; the working directory and the environment variables are reset"
+		}
+		err = nil
+	}
+	if err != nil {
+		logger.Error("Failed to execute", "error", err)
+		return nil, &ToolError{err}
+	}
+
+	logger.Debug("Execution completed", "return_code", resp.ReturnCode, "signal", resp.Signal, "timed_out", resp.TimedOut)
+	resp.Output = capOutput(ctx, stdout.String())
+	resp.ErrorOut = capOutput(ctx, stderr.String())
+	return resp, nil
+}
+
+// runOneShot runs the command line in a new shell process, and returns
+// the exit code and the signal name if it's killed by a signal.
+func (et *ExecTool) runOneShot(ctx context.Context, commandLine string, opts commandOptions, stdin string, stdout, stderr io.Writer) (int, string, error) {
+	cmd, err := et.command(ctx, opts, et.config.ShellPath(), "-c", commandLine)
+	if err != nil {
+		return 0, "", err
+	}
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
+	// Don't wait for the grandchildren holding the output after the
+	// process group is killed.
+	cmd.WaitDelay = time.Second
 
 	err = cmd.Run()
-	if err != nil && !errors.Is(err, &exec.ExitError{}) {
-		logger.Error("Failed to execute", "error", err)
-		return nil, &ToolError{err}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return 0, "", err
 	}
 	state := cmd.ProcessState
 	if state == nil {
-		logger.Error("Missing process state")
 		if err != nil {
-			return nil, err
+			return 0, "", err
 		}
-		return nil, errors.New("unknown error")
+		return 0, "", errors.New("unknown error")
 	}
+	return state.ExitCode(), exitSignal(state), nil
+}
 
-	logger.Debug("Execution completed", "return_code", state.ExitCode())
+type resetShellRequest struct{}
 
-	return &execCommandResponse{
-		ReturnCode: state.ExitCode(),
-		Output:     stdout.String(),
-		ErrorOut:   stderr.String(),
-	}, nil
+func (et *ExecTool) resetShell(ctx context.Context, req resetShellRequest) (string, error) {
+	getLogger(ctx).Info("Reset shell")
+	fmt.Println("Resetting the shell")
+	et.shell.reset()
+	return "the shell is reset to the initial working directory and environment variables", nil
 }
 
 // Close implements Manager interface.
 func (et *ExecTool) Close() error {
+	et.shell.reset()
+	et.stopAllJobs()
 	return nil
 }
 
 // ToolDefs implements Manager interface.
 func (et *ExecTool) ToolDefs(ctx context.Context) ([]ToolDefinition, error) {
-	return []ToolDefinition{
-		&toolDefinition[execCommandRequest, *execCommandResponse]{
+	var defs []ToolDefinition
+	if et.config.OneShot {
+		defs = append(defs, &toolDefinition[execCommandRequest, *execCommandResponse]{
 			name:        "exec_command",
 			description: "execute a command",
 			proc:        et.execCommand,
-		},
-	}, nil
+		})
+	} else {
+		defs = append(defs,
+			&toolDefinition[execCommandRequest, *execCommandResponse]{
+				name:        "exec_command",
+				description: "execute a command in a persistent shell; the working directory and the environment variables are kept for the following commands",
+				proc:        et.execCommand,
+			},
+			&toolDefinition[resetShellRequest, string]{
+				name:            "reset_shell",
+				description:     "restart the shell used by exec_command, resetting the working directory and the environment variables",
+				proc:            et.resetShell,
+				respName:        "result",
+				respDescription: "the result of the reset",
+			},
+		)
+	}
+	defs = append(defs, et.backgroundToolDefs()...)
+	return append(defs, &toolDefinition[readOutputRequest, *readOutputResponse]{
+		name:        "read_command_output",
+		description: "read the lines of a command output which was truncated, by its output_id",
+		proc:        et.readOutput,
+	}), nil
 }
@@ -3,6 +3,9 @@ package tools
 import (
 	"context"
 	"os"
+
+	"github.com/jmuk/sylvan/pkg/config"
+	"github.com/jmuk/sylvan/pkg/session"
 )
 
 // FileTools provides the tools/functions related to files
@@ -12,12 +15,14 @@ import (
 type FileTools struct {
 	root     *os.Root
 	rootPath string
+	config   config.FilesConfig
 }
 
 // NewFiles creates a new FileTools in the directory.
-func NewFiles(rootPath string) *FileTools {
+func NewFiles(rootPath string, c config.FilesConfig) *FileTools {
 	return &FileTools{
 		rootPath: rootPath,
+		config:   c,
 	}
 }
 
@@ -33,6 +38,20 @@ func (ft *FileTools) getRoot() (*os.Root, error) {
 	return ft.root, nil
 }
 
+// snapshot records the current state of the file in the session before
+// modifying it, so that the user can undo the change later.
+func (ft *FileTools) snapshot(ctx context.Context, root *os.Root, filename string) error {
+	s, ok := session.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if err := s.SnapshotFile(root, filename); err != nil {
+		getLogger(ctx).Error("Failed to snapshot", "filename", filename, "error", err)
+		return err
+	}
+	return nil
+}
+
 // Close closes the handle to the root.
 func (ft *FileTools) Close() error {
 	if ft.root == nil {
@@ -48,14 +67,19 @@ func (ft *FileTools) ToolDefs(ctx context.Context) ([]ToolDefinition, error) {
 	return []ToolDefinition{
 		&toolDefinition[readFileRequest, *readFileResponse]{
 			name:        "read_file",
-			description: "Read a file",
+			description: "Read a text file. The content is returned with the line number prefixed to each line as 'N: ', which is not a part of the file. Large files are truncated; use start_line and end_line to read the rest. Images are returned as attachments",
 			proc:        ft.readFile,
 		},
 		&toolDefinition[searchFilesRequest, *searchFilesResponse]{
 			name:        "search_files",
-			description: "return the list of file paths matching with the path patterns or contents",
+			description: "Search the files by the path pattern and/or grep the lines of the files, skipping binary files and the files ignored by .gitignore or .sylvanignore. With grep, it returns the matched lines with the line numbers; otherwise the matched file paths",
 			proc:        ft.searchFile,
 		},
+		&toolDefinition[listDirectoryRequest, *listDirectoryResponse]{
+			name:        "list_directory",
+			description: "Show the tree of a directory with the file sizes, skipping the files ignored by .gitignore or .sylvanignore. Large directories are collapsed",
+			proc:        ft.listDirectory,
+		},
 		&toolDefinition[writeFileRequest, string]{
 			name:            "write_file",
 			description:     "Write the content to a file; overwriting an existing one or create a new file",
@@ -70,6 +94,18 @@ func (ft *FileTools) ToolDefs(ctx context.Context) ([]ToolDefinition, error) {
 			respName:        "new_content",
 			respDescription: "the content to be stored in the file in case it's different from the request",
 		},
+		&toolDefinition[replaceInFileRequest, string]{
+			name:            "replace_in_file",
+			description:     "modify a file by replacing exact strings; preferred way to make small edits",
+			proc:            ft.replaceInFile,
+			respName:        "new_content",
+			respDescription: "the content to be stored in the file in case it's different from the request",
+		},
+		&toolDefinition[applyPatchRequest, *applyPatchResponse]{
+			name:        "apply_patch",
+			description: "apply a patch in the unified diff format to one or more files. Hunks are located by their context lines, so line numbers in the hunk headers can be approximate",
+			proc:        ft.applyPatch,
+		},
 		&toolDefinition[deleteFileRequest, *deleteFileResponse]{
 			name:        "delete_file",
 			description: "delete a file",
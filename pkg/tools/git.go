@@ -0,0 +1,178 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmuk/sylvan/pkg/git"
+)
+
+const gitLogDefaultCount = 10
+
+type gitStatusRequest struct {
+}
+
+type gitStatusResponse struct {
+	Branch string `json:"branch" jsonschema:"description=the current branch; empty if HEAD is detached"`
+	Status string `json:"status" jsonschema:"description=the output of git status in the porcelain format"`
+}
+
+type gitDiffRequest struct {
+	Base   string   `json:"base,omitempty" jsonschema:"description=the revision to compare the working tree with; the unstaged changes are shown when empty"`
+	Staged bool     `json:"staged,omitempty" jsonschema:"description=show the staged changes against the base (or HEAD) instead of the working tree"`
+	Stat   bool     `json:"stat,omitempty" jsonschema:"description=show only the diffstat instead of the full patch"`
+	Paths  []string `json:"paths,omitempty" jsonschema:"description=limit the diff to these paths"`
+}
+
+type gitDiffResponse struct {
+	Diff string `json:"diff" jsonschema:"description=the diff output"`
+}
+
+type gitLogRequest struct {
+	Revision string   `json:"revision,omitempty" jsonschema:"description=the revision or the range to show the log of; HEAD when empty"`
+	MaxCount int      `json:"max_count,omitempty" jsonschema:"description=the max number of commits to show. The default is 10"`
+	Patch    bool     `json:"patch,omitempty" jsonschema:"description=include the patch of each commit"`
+	Paths    []string `json:"paths,omitempty" jsonschema:"description=limit the log to the commits touching these paths"`
+}
+
+type gitLogResponse struct {
+	Log string `json:"log" jsonschema:"description=the log output"`
+}
+
+// GitTools provides read-only tools to inspect the git repository
+// of the project. They don't need the user confirmation.
+type GitTools struct {
+	dir  string
+	repo *git.Repo
+}
+
+// NewGitTools creates a new GitTools for the repository containing the directory.
+func NewGitTools(dir string) *GitTools {
+	return &GitTools{dir: dir}
+}
+
+func validateRevision(rev string) error {
+	if strings.HasPrefix(rev, "-") {
+		return &ToolError{fmt.Errorf("invalid revision %s", rev)}
+	}
+	return nil
+}
+
+func withPaths(args []string, paths []string) []string {
+	if len(paths) == 0 {
+		return args
+	}
+	return append(append(args, "--"), paths...)
+}
+
+func (gt *GitTools) gitStatus(ctx context.Context, req gitStatusRequest) (*gitStatusResponse, error) {
+	logger := getLogger(ctx)
+	logger.Debug("Git status")
+	fmt.Println("Checking git status")
+	branch, err := gt.repo.CurrentBranch(ctx)
+	if err != nil {
+		logger.Error("Failed to get the branch", "error", err)
+		return nil, &ToolError{err}
+	}
+	status, err := gt.repo.Run(ctx, "status", "--porcelain=v1", "--branch")
+	if err != nil {
+		logger.Error("Failed to get the status", "error", err)
+		return nil, &ToolError{err}
+	}
+	return &gitStatusResponse{
+		Branch: branch,
+		Status: status,
+	}, nil
+}
+
+func (gt *GitTools) gitDiff(ctx context.Context, req gitDiffRequest) (*gitDiffResponse, error) {
+	logger := getLogger(ctx)
+	logger.Debug("Git diff")
+	if err := validateRevision(req.Base); err != nil {
+		return nil, err
+	}
+	args := []string{"diff"}
+	if req.Stat {
+		args = append(args, "--stat")
+	}
+	if req.Staged {
+		args = append(args, "--cached")
+	}
+	if req.Base != "" {
+		args = append(args, req.Base)
+	}
+	fmt.Println("Checking", strings.Join(args, " "))
+	diff, err := gt.repo.Run(ctx, withPaths(args, req.Paths)...)
+	if err != nil {
+		logger.Error("Failed to get the diff", "error", err)
+		return nil, &ToolError{err}
+	}
+	return &gitDiffResponse{Diff: diff}, nil
+}
+
+func (gt *GitTools) gitLog(ctx context.Context, req gitLogRequest) (*gitLogResponse, error) {
+	logger := getLogger(ctx)
+	logger.Debug("Git log")
+	if err := validateRevision(req.Revision); err != nil {
+		return nil, err
+	}
+	maxCount := req.MaxCount
+	if maxCount <= 0 {
+		maxCount = gitLogDefaultCount
+	}
+	args := []string{"log", "--max-count=" + strconv.Itoa(maxCount), "--date=iso"}
+	if req.Patch {
+		args = append(args, "--patch")
+	} else {
+		args = append(args, "--stat")
+	}
+	if req.Revision != "" {
+		args = append(args, req.Revision)
+	}
+	fmt.Println("Checking", strings.Join(args, " "))
+	log, err := gt.repo.Run(ctx, withPaths(args, req.Paths)...)
+	if err != nil {
+		logger.Error("Failed to get the log", "error", err)
+		return nil, &ToolError{err}
+	}
+	return &gitLogResponse{Log: log}, nil
+}
+
+// Close implements Manager interface.
+func (gt *GitTools) Close() error {
+	return nil
+}
+
+// ToolDefs implements Manager interface. It returns no tools when the
+// directory is not in a git repository.
+func (gt *GitTools) ToolDefs(ctx context.Context) ([]ToolDefinition, error) {
+	if gt.repo == nil {
+		repo, err := git.Open(ctx, gt.dir)
+		if errors.Is(err, git.ErrNotRepository) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		gt.repo = repo
+	}
+	return []ToolDefinition{
+		&toolDefinition[gitStatusRequest, *gitStatusResponse]{
+			name:        "git_status",
+			description: "show the current branch and the status of the working tree of the git repository",
+			proc:        gt.gitStatus,
+		},
+		&toolDefinition[gitDiffRequest, *gitDiffResponse]{
+			name:        "git_diff",
+			description: "show the changes in the git repository",
+			proc:        gt.gitDiff,
+		},
+		&toolDefinition[gitLogRequest, *gitLogResponse]{
+			name:        "git_log",
+			description: "show the commit logs of the git repository",
+			proc:        gt.gitLog,
+		},
+	}, nil
+}
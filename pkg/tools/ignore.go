@@ -0,0 +1,163 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// The files listing the ignore patterns in the gitignore format.
+var ignoreFiles = []string{".gitignore", ".sylvanignore"}
+
+// matchGlob matches the slash-separated name with the pattern. In addition
+// to the syntax of path.Match, "**" as a path segment matches any number
+// of segments (including zero).
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// ignoreRule is a line in an ignore file.
+type ignoreRule struct {
+	// The directory containing the ignore file; "." for the root.
+	dir      string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func (r *ignoreRule) match(p string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel := p
+	if r.dir != "." {
+		if !strings.HasPrefix(p, r.dir+"/") {
+			return false
+		}
+		rel = p[len(r.dir)+1:]
+	}
+	if r.anchored {
+		return matchGlob(r.pattern, rel)
+	}
+	return matchGlob(r.pattern, path.Base(rel))
+}
+
+func parseIgnoreFile(dir string, data []byte) []*ignoreRule {
+	var rules []*ignoreRule
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := &ignoreRule{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// ignoreMatcher decides whether a path should be skipped, following the
+// ignore files found in the directories. The directories must be visited
+// from the parent to the children, as in fs.WalkDir.
+type ignoreMatcher struct {
+	fsys  fs.FS
+	rules []*ignoreRule
+	// When true, the ignore files are not loaded; .git is still skipped.
+	includeIgnored bool
+}
+
+func newIgnoreMatcher(fsys fs.FS) *ignoreMatcher {
+	return &ignoreMatcher{fsys: fsys}
+}
+
+// enterDir loads the ignore files in the directory.
+func (m *ignoreMatcher) enterDir(dir string) {
+	if m.includeIgnored {
+		return
+	}
+	for _, name := range ignoreFiles {
+		data, err := fs.ReadFile(m.fsys, path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		m.rules = append(m.rules, parseIgnoreFile(dir, data)...)
+	}
+}
+
+// ignored returns true if the path (relative to the root) is ignored.
+func (m *ignoreMatcher) ignored(p string, isDir bool) bool {
+	if path.Base(p) == ".git" {
+		return true
+	}
+	result := false
+	for _, r := range m.rules {
+		if r.match(p, isDir) {
+			result = !r.negate
+		}
+	}
+	return result
+}
+
+// walk walks the file tree under dir skipping the ignored files. The
+// callback is not called for the ignored entries.
+func (m *ignoreMatcher) walk(dir string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(m.fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fn(p, d, err)
+		}
+		if p != dir && m.ignored(p, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			m.enterDir(p)
+		}
+		return fn(p, d, nil)
+	})
+}
@@ -0,0 +1,173 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// The default depth of list_directory.
+	defaultListDepth = 2
+	// Directories with more entries than this are collapsed.
+	maxListEntriesPerDir = 100
+	// The max number of the entries in the whole tree.
+	maxListEntries = 1000
+)
+
+type listDirectoryRequest struct {
+	Path           string `json:"path,omitempty" jsonschema:"description=the directory to list from the current directory; defaults to '.'"`
+	Depth          int    `json:"depth,omitempty" jsonschema:"description=the number of the levels to descend; 1 lists only the direct children. Defaults to 2"`
+	IncludeIgnored bool   `json:"include_ignored,omitempty" jsonschema:"description=also list the files excluded by .gitignore or .sylvanignore"`
+}
+
+type listDirectoryResponse struct {
+	Tree      string `json:"tree" jsonschema:"description=one entry per line indented by two spaces per level. Directories end with '/' and the ones not descended show the number of the entries; files show their sizes and symbolic links show their targets"`
+	Truncated string `json:"truncated,omitempty" jsonschema:"description=set when some entries are omitted"`
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// treeLister renders the directory tree for list_directory.
+type treeLister struct {
+	root    *os.Root
+	m       *ignoreMatcher
+	depth   int
+	b       strings.Builder
+	count   int
+	omitted int
+}
+
+// entries returns the non-ignored entries in the directory, the
+// directories first.
+func (l *treeLister) entries(dir string) ([]fs.DirEntry, error) {
+	l.m.enterDir(dir)
+	all, err := fs.ReadDir(l.root.FS(), dir)
+	if err != nil {
+		return nil, err
+	}
+	var results []fs.DirEntry
+	for _, e := range all {
+		if !l.m.ignored(path.Join(dir, e.Name()), e.IsDir()) {
+			results = append(results, e)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].IsDir() && !results[j].IsDir()
+	})
+	return results, nil
+}
+
+func (l *treeLister) list(dir string, level int) error {
+	entries, err := l.entries(dir)
+	if err != nil {
+		return err
+	}
+	indent := strings.Repeat("  ", level)
+	for i, e := range entries {
+		if i >= maxListEntriesPerDir || l.count >= maxListEntries {
+			rest := len(entries) - i
+			fmt.Fprintf(&l.b, "%s... %d more entries\n", indent, rest)
+			l.omitted += rest
+			return nil
+		}
+		l.count++
+		p := path.Join(dir, e.Name())
+		switch {
+		case e.IsDir():
+			if level+1 < l.depth {
+				fmt.Fprintf(&l.b, "%s%s/\n", indent, e.Name())
+				if err := l.list(p, level+1); err != nil {
+					fmt.Fprintf(&l.b, "%s  (failed to read: %v)\n", indent, err)
+				}
+				continue
+			}
+			children, err := l.entries(p)
+			if err != nil {
+				fmt.Fprintf(&l.b, "%s%s/ (failed to read: %v)\n", indent, e.Name(), err)
+			} else {
+				fmt.Fprintf(&l.b, "%s%s/ (%d entries)\n", indent, e.Name(), len(children))
+			}
+		case e.Type()&fs.ModeSymlink != 0:
+			target, err := l.root.Readlink(p)
+			if err != nil {
+				target = "?"
+			}
+			fmt.Fprintf(&l.b, "%s%s -> %s\n", indent, e.Name(), target)
+		default:
+			info, err := e.Info()
+			if err != nil {
+				fmt.Fprintf(&l.b, "%s%s\n", indent, e.Name())
+				continue
+			}
+			fmt.Fprintf(&l.b, "%s%s (%s)\n", indent, e.Name(), formatSize(info.Size()))
+		}
+	}
+	return nil
+}
+
+func (ft *FileTools) listDirectory(ctx context.Context, req listDirectoryRequest) (*listDirectoryResponse, error) {
+	logger := getLogger(ctx)
+	logger.Debug("List directory")
+	if req.Depth < 0 {
+		return nil, &ToolError{errors.New("depth must not be negative")}
+	}
+	dir := path.Clean(filepath.ToSlash(req.Path))
+	if req.Path == "" {
+		dir = "."
+	}
+	depth := req.Depth
+	if depth == 0 {
+		depth = defaultListDepth
+	}
+	fmt.Println("Listing", dir)
+	root, err := ft.getRoot()
+	if err != nil {
+		return nil, err
+	}
+	s, err := root.Stat(dir)
+	if err != nil {
+		logger.Error("Failed to stat", "error", err)
+		return nil, &ToolError{err}
+	}
+	if !s.IsDir() {
+		return nil, &ToolError{fmt.Errorf("%s is not a directory", dir)}
+	}
+
+	l := &treeLister{root: root, m: newIgnoreMatcher(root.FS()), depth: depth}
+	l.m.includeIgnored = req.IncludeIgnored
+	// Load the ignore files of the parent directories.
+	if dir != "." {
+		l.m.enterDir(".")
+		elems := strings.Split(dir, "/")
+		for i := 1; i < len(elems); i++ {
+			l.m.enterDir(strings.Join(elems[:i], "/"))
+		}
+	}
+	if err := l.list(dir, 0); err != nil {
+		logger.Error("Failed to list", "error", err)
+		return nil, &ToolError{err}
+	}
+	resp := &listDirectoryResponse{Tree: l.b.String()}
+	if l.omitted > 0 {
+		resp.Truncated = fmt.Sprintf("%d entries are omitted; list the subdirectories to see them", l.omitted)
+	}
+	return resp, nil
+}
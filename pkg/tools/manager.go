@@ -2,6 +2,7 @@ package tools
 
 import (
 	"context"
+	"fmt"
 	"sort"
 
 	"github.com/jmuk/sylvan/pkg/config"
@@ -15,25 +16,51 @@ type Manager interface {
 	Close() error
 }
 
-// NewManagers creates the list of the managers from the config.
-func NewManagers(cwd string, c *config.Config) []Manager {
-	mcpManagers := map[string]Manager{}
+// NewMCPs creates the MCPTools for the enabled MCP servers in the config,
+// sorted by the names. cwd is the project root for the variables in the
+// config. The servers whose tool names conflict with the earlier ones are
+// skipped with a warning.
+func NewMCPs(cwd string, c *config.Config) []*MCPTool {
+	var mts []*MCPTool
 	for _, mcpc := range c.MCP {
-		mcpManagers[mcpc.Name] = NewMCP(mcpc)
+		if mcpc.Disabled {
+			continue
+		}
+		if mt := NewMCP(mcpc, cwd); mt != nil {
+			mts = append(mts, mt)
+		}
 	}
-	var keys []string
-	for k := range mcpManagers {
-		if mcpManagers[k] != nil {
-			keys = append(keys, k)
+	sort.SliceStable(mts, func(i, j int) bool {
+		return mts[i].Name() < mts[j].Name()
+	})
+	prefixes := map[string]string{}
+	results := make([]*MCPTool, 0, len(mts))
+	for _, mt := range mts {
+		prefix := mcpToolPrefix(mt.Name())
+		if other, ok := prefixes[prefix]; ok {
+			fmt.Printf("Skipping MCP server %s: its tool names conflict with %s\n", mt.Name(), other)
+			continue
 		}
+		prefixes[prefix] = mt.Name()
+		results = append(results, mt)
 	}
-	sort.Strings(keys)
-	mgrs := make([]Manager, 0, len(keys)+2)
-	for _, k := range keys {
-		mgrs = append(mgrs, mcpManagers[k])
+	return results
+}
+
+// NewBuiltinManagers creates the managers of the builtin tools.
+func NewBuiltinManagers(cwd string, c *config.Config) []Manager {
+	return []Manager{NewFiles(cwd, c.Files), NewExecTool(cwd, c.Exec), NewGitTools(cwd)}
+}
+
+// NewManagers creates the list of the managers from the config.
+func NewManagers(cwd string, c *config.Config) []Manager {
+	mts := NewMCPs(cwd, c)
+	mgrs := make([]Manager, 0, len(mts)+3)
+	for _, mt := range mts {
+		mgrs = append(mgrs, mt)
 	}
 
-	// Append files and exec at the end so that that implementation will
-	// always be used even if some MCP tool names happen to conflict.
-	return append(mgrs, NewFiles(cwd), NewExecTool())
+	// The names of the MCP tools are prefixed by the server names, so
+	// they don't conflict with the builtin tools.
+	return append(mgrs, NewBuiltinManagers(cwd, c)...)
 }
@@ -1,6 +1,7 @@
 package tools
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,12 +9,16 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/invopop/jsonschema"
 	"github.com/jmuk/sylvan/pkg/chat/parts"
 	"github.com/jmuk/sylvan/pkg/config"
+	"github.com/jmuk/sylvan/pkg/oauth"
 	"github.com/jmuk/sylvan/pkg/session"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
@@ -22,19 +27,52 @@ type transportFactory interface {
 	newTransport() mcp.Transport
 }
 
+// fallbackFactory is a transportFactory which has an alternative
+// transport, used when the server doesn't support the first one.
+type fallbackFactory interface {
+	transportFactory
+	// newFallbackTransport returns the alternative transport, or nil if
+	// there's no alternative.
+	newFallbackTransport() mcp.Transport
+	// useFallback makes newTransport return the alternative from now on.
+	useFallback()
+}
+
 type commandFactory struct {
-	command []string
+	// The config with the variables expanded; only the command and its
+	// environment are used.
+	config config.MCPConfig
 }
 
 func (cf *commandFactory) newTransport() mcp.Transport {
+	cmd := exec.Command(cf.config.Command[0], cf.config.Command[1:]...)
+	cmd.Dir = cf.config.Cwd
+	cmd.Env = cf.config.Environ(os.Environ())
 	return &mcp.CommandTransport{
-		Command: exec.Command(cf.command[0], cf.command[1:]...),
+		Command: cmd,
 	}
 }
 
+// errorFactory is the transportFactory for the invalid configs, so that
+// the error is reported when connecting.
+type errorFactory struct {
+	err error
+}
+
+func (ef *errorFactory) newTransport() mcp.Transport {
+	return ef
+}
+
+// Connect implements mcp.Transport.
+func (ef *errorFactory) Connect(ctx context.Context) (mcp.Connection, error) {
+	return nil, ef.err
+}
+
 type httpFactory struct {
-	endpoint string
-	headers  http.Header
+	endpoint  string
+	headers   http.Header
+	transport config.MCPTransport
+	auth      *oauth.Authorizer
 }
 
 type headerAddingRoundTripper struct {
@@ -51,19 +89,42 @@ func (rt *headerAddingRoundTripper) RoundTrip(r *http.Request) (*http.Response,
 	return rt.roundTripper.RoundTrip(r)
 }
 
-func (hsf *httpFactory) newTransport() mcp.Transport {
-	transport := &mcp.SSEClientTransport{
-		Endpoint: hsf.endpoint,
-	}
+func (hsf *httpFactory) httpClient() *http.Client {
+	rt := hsf.auth.RoundTripper(http.DefaultTransport)
 	if len(hsf.headers) > 0 {
-		transport.HTTPClient = &http.Client{
-			Transport: &headerAddingRoundTripper{
-				headers:      hsf.headers,
-				roundTripper: http.DefaultTransport,
-			},
+		rt = &headerAddingRoundTripper{
+			headers:      hsf.headers,
+			roundTripper: rt,
 		}
 	}
-	return transport
+	return &http.Client{Transport: rt}
+}
+
+func (hsf *httpFactory) newTransport() mcp.Transport {
+	if hsf.transport == config.MCPTransportSSE {
+		return &mcp.SSEClientTransport{
+			Endpoint:   hsf.endpoint,
+			HTTPClient: hsf.httpClient(),
+		}
+	}
+	return &mcp.StreamableClientTransport{
+		Endpoint:   hsf.endpoint,
+		HTTPClient: hsf.httpClient(),
+	}
+}
+
+func (hsf *httpFactory) newFallbackTransport() mcp.Transport {
+	if hsf.transport != "" && hsf.transport != config.MCPTransportAuto {
+		return nil
+	}
+	return &mcp.SSEClientTransport{
+		Endpoint:   hsf.endpoint,
+		HTTPClient: hsf.httpClient(),
+	}
+}
+
+func (hsf *httpFactory) useFallback() {
+	hsf.transport = config.MCPTransportSSE
 }
 
 // MCPTool is a tool provided by MCP.
@@ -71,16 +132,82 @@ type MCPTool struct {
 	name    string
 	client  *mcp.Client
 	factory transportFactory
+	// The config it's created from; empty when created without NewMCP.
+	config config.MCPConfig
+	// The timeouts to start the server and of each tool call; zero for
+	// no timeout.
+	startupTimeout time.Duration
+	timeout        time.Duration
 
+	mu            sync.Mutex
 	clientSession *mcp.ClientSession
+	// connecting is set while connecting to the server, and closed when
+	// the attempt finishes.
+	connecting chan struct{}
+	// The caches of the lists; nil until fetched or after the server
+	// notifies the change.
+	tools     []ToolDefinition
+	resources []*mcp.Resource
+	prompts   []*mcp.Prompt
+	// toolsChanged is set when the server notifies the change of the
+	// tools, so that the agent rebuilds the tool definitions.
+	toolsChanged bool
+	// The URIs of the subscribed resources and the updated ones.
+	subscribed map[string]bool
+	updated    map[string]bool
+	// The consecutive failures to connect; the next attempt waits until
+	// retryAt.
+	failures int
+	retryAt  time.Time
+	lastErr  error
+	// sampler generates the text for the sampling requests.
+	sampler Sampler
+	// prefetching is set while the lists are fetched in the background.
+	prefetching bool
 }
 
+// The range of the backoff to reconnect after failures.
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
 func newMCPTool() *MCPTool {
 	var mt *MCPTool
 	clientOpts := &mcp.ClientOptions{
 		LoggingMessageHandler: func(ctx context.Context, msg *mcp.LoggingMessageRequest) {
 			mt.logMessage(ctx, msg)
 		},
+		CreateMessageHandler: func(ctx context.Context, req *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+			return mt.createMessage(ctx, req)
+		},
+		ElicitationHandler: func(ctx context.Context, req *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+			return mt.elicit(ctx, req)
+		},
+		ToolListChangedHandler: func(ctx context.Context, req *mcp.ToolListChangedRequest) {
+			mt.mu.Lock()
+			defer mt.mu.Unlock()
+			mt.tools = nil
+			mt.toolsChanged = true
+		},
+		ResourceListChangedHandler: func(ctx context.Context, req *mcp.ResourceListChangedRequest) {
+			mt.mu.Lock()
+			defer mt.mu.Unlock()
+			mt.resources = nil
+		},
+		PromptListChangedHandler: func(ctx context.Context, req *mcp.PromptListChangedRequest) {
+			mt.mu.Lock()
+			defer mt.mu.Unlock()
+			mt.prompts = nil
+		},
+		ResourceUpdatedHandler: func(ctx context.Context, req *mcp.ResourceUpdatedNotificationRequest) {
+			mt.mu.Lock()
+			defer mt.mu.Unlock()
+			if mt.updated == nil {
+				mt.updated = map[string]bool{}
+			}
+			mt.updated[req.Params.URI] = true
+		},
 	}
 	mt = &MCPTool{
 		client: mcp.NewClient(
@@ -94,17 +221,23 @@ func newMCPTool() *MCPTool {
 	return mt
 }
 
-// NewCommandMCP creates a new MCPTool using the command-line.
-func NewCommandMCP(name string, command []string) *MCPTool {
+// NewCommandMCP creates a new MCPTool using the command-line. The
+// environment variables and the working directory of the command are
+// taken from the config.
+func NewCommandMCP(name string, cfg config.MCPConfig) *MCPTool {
 	mt := newMCPTool()
+	mt.name = name
 	mt.factory = &commandFactory{
-		command: command,
+		config: cfg,
 	}
 	return mt
 }
 
 // NewHTTPMCP creates a new MCPTool with the given HTTP endpoint.
-func NewHTTPMCP(name, endpoint string, headers map[string]string) *MCPTool {
+// When the transport is auto, the Streamable HTTP transport is tried first
+// and the HTTP+SSE transport is used when it fails. The OAuth authorization
+// starts when the server requires it.
+func NewHTTPMCP(name, endpoint string, headers map[string]string, transport config.MCPTransport, oauthConfig config.MCPOAuthConfig) *MCPTool {
 	var h http.Header
 	if len(headers) > 0 {
 		h = http.Header{}
@@ -113,34 +246,74 @@ func NewHTTPMCP(name, endpoint string, headers map[string]string) *MCPTool {
 		}
 	}
 	mt := newMCPTool()
+	mt.name = name
 	mt.factory = &httpFactory{
-		endpoint: endpoint,
-		headers:  h,
+		endpoint:  endpoint,
+		headers:   h,
+		transport: transport,
+		auth:      oauth.NewAuthorizer(name, endpoint, oauthConfig),
 	}
 	return mt
 }
 
-// NewMCP creates a new MCPTool based on the config.
-func NewMCP(cfg config.MCPConfig) *MCPTool {
+// NewMCP creates a new MCPTool based on the config. The variables in the
+// config are expanded with the project root, which is also advertised to
+// the server as the root.
+func NewMCP(cfg config.MCPConfig, projectRoot string) *MCPTool {
+	var name string
 	if cfg.Endpoint != "" {
-		name := cfg.Name
-		if name == "" {
-			name = cfg.Endpoint
-		}
-		return NewHTTPMCP(name, cfg.Endpoint, cfg.RequestHeaders)
+		name = cmp.Or(cfg.Name, cfg.Endpoint)
 	} else if len(cfg.Command) > 0 {
-		name := cfg.Name
-		if name == "" {
-			name = cfg.Command[0]
-		}
-		return NewCommandMCP(name, cfg.Command)
+		name = cmp.Or(cfg.Name, cfg.Command[0])
+	} else {
+		return nil
 	}
-	return nil
+	var mt *MCPTool
+	expanded, err := cfg.Expand(projectRoot)
+	if err != nil {
+		mt = newMCPTool()
+		mt.name = name
+		mt.factory = &errorFactory{err}
+	} else if expanded.Endpoint != "" {
+		mt = NewHTTPMCP(name, expanded.Endpoint, expanded.RequestHeaders, expanded.Transport, expanded.OAuth)
+	} else {
+		mt = NewCommandMCP(name, expanded)
+	}
+	if projectRoot != "" {
+		mt.setRoot(projectRoot)
+	}
+	mt.config = cfg
+	mt.startupTimeout = cfg.StartupTimeout()
+	mt.timeout = cfg.Timeout()
+	return mt
+}
+
+// The separator of the server name and the tool name of the MCP tools.
+const mcpNameSeparator = "__"
+
+// mcpToolPrefix returns the prefix of the tool names of the MCP server.
+// The characters other than [a-zA-Z0-9_-] in the server name are replaced
+// with '_'.
+func mcpToolPrefix(server string) string {
+	return strings.Map(func(r rune) rune {
+		if IsAlnum(r) || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, server) + mcpNameSeparator
+}
+
+// mcpToolName returns the namespaced name of the tool of the MCP server,
+// e.g. github__search.
+func mcpToolName(server, tool string) string {
+	return mcpToolPrefix(server) + tool
 }
 
 type mcpToolDefinition struct {
 	name        string
 	description string
+	// The original name of the tool in the MCP server.
+	toolName string
 
 	inSchema  *jsonschema.Schema
 	outSchema *jsonschema.Schema
@@ -165,18 +338,87 @@ func (mtd *mcpToolDefinition) ResponseSchema() *jsonschema.Schema {
 }
 
 func (mtd *mcpToolDefinition) process(ctx context.Context, in map[string]any) (any, []*parts.Part, error) {
-	return mtd.mt.process(ctx, mtd.name, in)
+	return mtd.mt.process(ctx, mtd.toolName, in)
+}
+
+// Name returns the name of the MCP server.
+func (mt *MCPTool) Name() string {
+	return mt.name
+}
+
+// Config returns the config the MCPTool is created from.
+func (mt *MCPTool) Config() config.MCPConfig {
+	return mt.config
+}
+
+// Ping connects to the server if not yet, and checks the server is
+// responding. The connection is dropped when it fails, so that the next
+// use connects again.
+func (mt *MCPTool) Ping(ctx context.Context) error {
+	sess, err := mt.getSession(ctx)
+	if err != nil {
+		return err
+	}
+	if err := sess.Ping(ctx, nil); err != nil {
+		return errors.Join(err, mt.Close())
+	}
+	return nil
 }
 
+// ToolsChanged returns true once after the server notifies the change of
+// the tools.
+func (mt *MCPTool) ToolsChanged() bool {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	changed := mt.toolsChanged
+	mt.toolsChanged = false
+	return changed
+}
+
+// Close closes the session. The next use connects again without waiting
+// for the backoff.
 func (mt *MCPTool) Close() error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
 	var err error
 	if mt.clientSession != nil {
 		err = mt.clientSession.Close()
-		mt.clientSession = nil
 	}
+	mt.dropSession()
+	// The ongoing attempt, if any, closes its session by itself.
+	mt.connecting = nil
+	mt.failures = 0
+	mt.retryAt = time.Time{}
+	mt.lastErr = nil
 	return err
 }
 
+// dropSession forgets the session and the states tied to it. mt.mu must be
+// held.
+func (mt *MCPTool) dropSession() {
+	mt.clientSession = nil
+	mt.tools = nil
+	mt.resources = nil
+	mt.prompts = nil
+	mt.subscribed = nil
+}
+
+// watch waits for the session to be closed by the server, e.g. the command
+// crashes, and drops it so that the next use reconnects.
+func (mt *MCPTool) watch(ctx context.Context, cs *mcp.ClientSession) {
+	err := cs.Wait()
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if mt.clientSession != cs {
+		// Closed by Close().
+		return
+	}
+	mt.dropSession()
+	if logger, lerr := session.LoggerFromContext(ctx, "mcp"); lerr == nil {
+		logger.Warn("The MCP server is disconnected", "name", mt.name, "error", err)
+	}
+}
+
 func (mt *MCPTool) logMessage(ctx context.Context, msg *mcp.LoggingMessageRequest) {
 	s, ok := session.FromContext(ctx)
 	if !ok {
@@ -202,8 +444,7 @@ func (mt *MCPTool) logMessage(ctx context.Context, msg *mcp.LoggingMessageReques
 	logger.Log(ctx, lvl, "log request", "data", p.Data)
 }
 
-func (mt *MCPTool) newSession(ctx context.Context) (*mcp.ClientSession, error) {
-	transport := mt.factory.newTransport()
+func (mt *MCPTool) connect(ctx context.Context, transport mcp.Transport) (*mcp.ClientSession, error) {
 	if s, ok := session.FromContext(ctx); ok {
 		logname := strings.Replace(mt.name, "/", "_", -1)
 		if len(logname) > 64 {
@@ -213,6 +454,13 @@ func (mt *MCPTool) newSession(ctx context.Context) (*mcp.ClientSession, error) {
 		if err != nil {
 			return nil, err
 		}
+		if ct, ok := transport.(*mcp.CommandTransport); ok {
+			stderrFile, err := s.GetLogFile(fmt.Sprintf("mcp-%s-stderr.txt", logname))
+			if err != nil {
+				return nil, err
+			}
+			ct.Command.Stderr = stderrFile
+		}
 		transport = &mcp.LoggingTransport{
 			Transport: transport,
 			Writer:    logFile,
@@ -221,36 +469,137 @@ func (mt *MCPTool) newSession(ctx context.Context) (*mcp.ClientSession, error) {
 	return mt.client.Connect(ctx, transport, nil)
 }
 
+func (mt *MCPTool) newSession(ctx context.Context) (*mcp.ClientSession, error) {
+	cs, err := mt.connect(ctx, mt.factory.newTransport())
+	if err == nil {
+		return cs, nil
+	}
+	ff, ok := mt.factory.(fallbackFactory)
+	if !ok {
+		return nil, err
+	}
+	transport := ff.newFallbackTransport()
+	if transport == nil {
+		return nil, err
+	}
+	logger, lerr := session.LoggerFromContext(ctx, "mcp")
+	if lerr != nil {
+		return nil, errors.Join(err, lerr)
+	}
+	logger.Info("Failed to connect, falling back to the HTTP+SSE transport", "name", mt.name, "error", err)
+	cs, fallbackErr := mt.connect(ctx, transport)
+	if fallbackErr != nil {
+		return nil, errors.Join(err, fallbackErr)
+	}
+	ff.useFallback()
+	return cs, nil
+}
+
+// reconnectBackoff returns the wait before the next attempt to connect
+// after the given number of consecutive failures.
+func reconnectBackoff(failures int) time.Duration {
+	backoff := minReconnectBackoff
+	for i := 1; i < failures && backoff < maxReconnectBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxReconnectBackoff)
+}
+
+// getSession returns the session, connecting to the server if needed. The
+// connection is made without holding mt.mu, since it may take long (e.g.
+// the OAuth flow in the browser) and the server may send notifications
+// during the initialization; the concurrent callers wait for it instead.
 func (mt *MCPTool) getSession(ctx context.Context) (*mcp.ClientSession, error) {
+	mt.mu.Lock()
+	for mt.connecting != nil {
+		ch := mt.connecting
+		mt.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		mt.mu.Lock()
+	}
 	if mt.clientSession != nil {
+		defer mt.mu.Unlock()
 		return mt.clientSession, nil
 	}
-	cs, err := mt.newSession(ctx)
+	if wait := time.Until(mt.retryAt); wait > 0 {
+		defer mt.mu.Unlock()
+		return nil, fmt.Errorf("failed to connect to %s, retrying in %s: %w", mt.name, wait.Round(time.Second), mt.lastErr)
+	}
+	ch := make(chan struct{})
+	defer close(ch)
+	mt.connecting = ch
+	mt.mu.Unlock()
+
+	connectCtx := ctx
+	if mt.startupTimeout > 0 {
+		var cancel context.CancelFunc
+		connectCtx, cancel = context.WithTimeout(ctx, mt.startupTimeout)
+		defer cancel()
+	}
+	cs, err := mt.newSession(connectCtx)
+
+	mt.mu.Lock()
+	if mt.connecting != ch {
+		// Closed while connecting.
+		mt.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.Join(fmt.Errorf("%s is closed while connecting", mt.name), cs.Close())
+	}
+	defer mt.mu.Unlock()
+	mt.connecting = nil
 	if err != nil {
+		mt.failures++
+		mt.retryAt = time.Now().Add(reconnectBackoff(mt.failures))
+		mt.lastErr = err
 		return nil, err
 	}
+	mt.failures = 0
+	mt.retryAt = time.Time{}
+	mt.lastErr = nil
 	mt.clientSession = cs
+	go mt.watch(context.WithoutCancel(ctx), cs)
 	return cs, nil
 }
 
 func (mt *MCPTool) process(ctx context.Context, name string, in map[string]any) (any, []*parts.Part, error) {
+	// The failures of the server, such as a crash or the backoff to
+	// reconnect, are reported to the agent rather than ending the chat,
+	// unless the caller is canceled.
 	sess, err := mt.getSession(ctx)
 	if err != nil {
-		return nil, nil, err
-	}
-	var logger *slog.Logger
-	if s, ok := session.FromContext(ctx); ok {
-		var err error
-		if logger, err = s.GetLogger("mcp-tool"); err != nil {
+		if ctx.Err() != nil {
 			return nil, nil, err
 		}
+		return nil, nil, &ToolError{err}
+	}
+	logger, err := session.LoggerFromContext(ctx, "mcp-tool")
+	if err != nil {
+		return nil, nil, err
+	}
+	callCtx := ctx
+	if mt.timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, mt.timeout)
+		defer cancel()
 	}
-	result, err := sess.CallTool(ctx, &mcp.CallToolParams{
+	result, err := sess.CallTool(callCtx, &mcp.CallToolParams{
 		Name:      name,
 		Arguments: in,
 	})
 	if err != nil {
-		return nil, nil, err
+		if ctx.Err() != nil {
+			return nil, nil, err
+		}
+		if mt.timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+			return nil, nil, &ToolError{fmt.Errorf("timed out after %s", mt.timeout)}
+		}
+		return nil, nil, &ToolError{err}
 	}
 	if result.IsError {
 		messages := &strings.Builder{}
@@ -265,20 +614,8 @@ func (mt *MCPTool) process(ctx context.Context, name string, in map[string]any)
 	}
 	var ps []*parts.Part
 	for _, content := range result.Content {
-		p := &parts.Part{}
-		if ac, ok := content.(*mcp.AudioContent); ok {
-			p.Audio = &parts.Blob{
-				MimeType: ac.MIMEType,
-				Data:     ac.Data,
-			}
-		} else if ic, ok := content.(*mcp.ImageContent); ok {
-			p.Image = &parts.Blob{
-				MimeType: ic.MIMEType,
-				Data:     ic.Data,
-			}
-		} else if tc, ok := content.(*mcp.TextContent); ok {
-			p.Text = tc.Text
-		} else {
+		p, ok := contentToPart(content)
+		if !ok {
 			logger.Error("unknown content", "content", content)
 			continue
 		}
@@ -287,13 +624,37 @@ func (mt *MCPTool) process(ctx context.Context, name string, in map[string]any)
 	return result.StructuredContent, ps, nil
 }
 
-// ToolDefs creates the list of ToolDefinitions for the MCPTool.
+// contentToPart converts the content of MCP to a part.
+func contentToPart(content mcp.Content) (*parts.Part, bool) {
+	switch c := content.(type) {
+	case *mcp.AudioContent:
+		return &parts.Part{Audio: &parts.Blob{MimeType: c.MIMEType, Data: c.Data}}, true
+	case *mcp.ImageContent:
+		return &parts.Part{Image: &parts.Blob{MimeType: c.MIMEType, Data: c.Data}}, true
+	case *mcp.TextContent:
+		return &parts.Part{Text: c.Text}, true
+	case *mcp.EmbeddedResource:
+		if c.Resource == nil {
+			return nil, false
+		}
+		return resourceToPart(c.Resource), true
+	}
+	return nil, false
+}
+
+// ToolDefs creates the list of ToolDefinitions for the MCPTool. The list
+// is cached until the server notifies the change.
 func (mt *MCPTool) ToolDefs(ctx context.Context) ([]ToolDefinition, error) {
-	session, err := mt.newSession(ctx)
+	session, err := mt.getSession(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
+	mt.mu.Lock()
+	cached := mt.tools
+	mt.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
 	var cursor string
 	var results []ToolDefinition
 	for {
@@ -324,8 +685,9 @@ func (mt *MCPTool) ToolDefs(ctx context.Context) ([]ToolDefinition, error) {
 				}
 			}
 			results = append(results, &mcpToolDefinition{
-				name:        t.Name,
+				name:        mcpToolName(mt.name, t.Name),
 				description: t.Description,
+				toolName:    t.Name,
 				inSchema:    inSchema,
 				outSchema:   outSchema,
 				mt:          mt,
@@ -336,5 +698,10 @@ func (mt *MCPTool) ToolDefs(ctx context.Context) ([]ToolDefinition, error) {
 		}
 		cursor = tools.NextCursor
 	}
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if mt.clientSession == session {
+		mt.tools = results
+	}
 	return results, nil
 }
@@ -0,0 +1,231 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmuk/sylvan/pkg/chat/parts"
+	"github.com/manifoldco/promptui"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Sampler generates the text for the sampling requests of the MCP servers,
+// and returns the text and the name of the model.
+type Sampler func(ctx context.Context, systemPrompt string, messages []parts.Part) (string, string, error)
+
+// SetSampler sets the sampler for the sampling requests of the server.
+// The requests are rejected when it's not set.
+func (mt *MCPTool) SetSampler(s Sampler) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.sampler = s
+}
+
+// setRoot advertises the project directory as the root to the server.
+func (mt *MCPTool) setRoot(projectRoot string) {
+	mt.client.AddRoots(&mcp.Root{
+		URI:  (&url.URL{Scheme: "file", Path: filepath.ToSlash(projectRoot)}).String(),
+		Name: filepath.Base(projectRoot),
+	})
+}
+
+// createMessage handles the sampling request from the server, after the
+// user approves it.
+func (mt *MCPTool) createMessage(ctx context.Context, req *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+	mt.mu.Lock()
+	sampler := mt.sampler
+	mt.mu.Unlock()
+	if sampler == nil {
+		return nil, errors.New("sampling is not available")
+	}
+	p := req.Params
+	var msgs []parts.Part
+	fmt.Printf("MCP server %s requests a completion.\n", mt.name)
+	if p.SystemPrompt != "" {
+		fmt.Println("System prompt:", p.SystemPrompt)
+	}
+	for _, m := range p.Messages {
+		part, ok := contentToPart(m.Content)
+		if !ok {
+			return nil, fmt.Errorf("unsupported content %T", m.Content)
+		}
+		if len(p.Messages) > 1 {
+			// The agents take a single message; the roles are
+			// written in the text.
+			msgs = append(msgs, parts.Part{Text: string(m.Role) + ":"})
+		}
+		msgs = append(msgs, *part)
+		if part.Text != "" {
+			fmt.Printf("%s: %s\n", m.Role, part.Text)
+		} else {
+			fmt.Printf("%s: (%T)\n", m.Role, m.Content)
+		}
+	}
+	answer, err := confirmWith(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if answer != confirmationYes {
+		return nil, errors.New("user rejected the sampling request")
+	}
+	text, model, err := sampler(ctx, p.SystemPrompt, msgs)
+	if err != nil {
+		return nil, err
+	}
+	return &mcp.CreateMessageResult{
+		Content:    &mcp.TextContent{Text: text},
+		Model:      model,
+		Role:       "assistant",
+		StopReason: "endTurn",
+	}, nil
+}
+
+// elicit asks the user to fill in the form requested by the server.
+func (mt *MCPTool) elicit(ctx context.Context, req *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+	p := req.Params
+	fmt.Printf("MCP server %s asks: %s\n", mt.name, p.Message)
+	schema, _ := p.RequestedSchema.(map[string]any)
+	properties, _ := schema["properties"].(map[string]any)
+	accept := "Respond"
+	if len(properties) == 0 {
+		accept = "Accept"
+	}
+	sel := promptui.Select{
+		Label: "Answer",
+		Items: []string{accept, "Decline", "Cancel"},
+	}
+	idx, _, err := sel.Run()
+	if errors.Is(err, promptui.ErrInterrupt) || idx == 2 {
+		return &mcp.ElicitResult{Action: "cancel"}, nil
+	} else if err != nil {
+		return nil, err
+	} else if idx == 1 {
+		return &mcp.ElicitResult{Action: "decline"}, nil
+	}
+
+	var required []string
+	if rs, ok := schema["required"].([]any); ok {
+		for _, r := range rs {
+			if s, ok := r.(string); ok {
+				required = append(required, s)
+			}
+		}
+	}
+	content := map[string]any{}
+	for _, name := range propertyNames(properties, required) {
+		prop, _ := properties[name].(map[string]any)
+		v, err := elicitProperty(name, prop, slices.Contains(required, name))
+		if errors.Is(err, promptui.ErrInterrupt) {
+			return &mcp.ElicitResult{Action: "cancel"}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			content[name] = v
+		}
+	}
+	return &mcp.ElicitResult{Action: "accept", Content: content}, nil
+}
+
+// propertyNames returns the names of the properties, the required ones
+// first in their order and then the others sorted.
+func propertyNames(properties map[string]any, required []string) []string {
+	var names, others []string
+	for _, name := range required {
+		if _, ok := properties[name]; ok {
+			names = append(names, name)
+		}
+	}
+	for name := range properties {
+		if !slices.Contains(required, name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append(names, others...)
+}
+
+// elicitProperty asks the user the value of a property of the form. It
+// returns nil when an optional property is left empty.
+func elicitProperty(name string, prop map[string]any, required bool) (any, error) {
+	label := name
+	if title, ok := prop["title"].(string); ok && title != "" {
+		label = title
+	}
+	if desc, ok := prop["description"].(string); ok && desc != "" {
+		label += " (" + desc + ")"
+	}
+	typ, _ := prop["type"].(string)
+
+	if enum, ok := prop["enum"].([]any); ok && len(enum) > 0 {
+		var items []string
+		if names, ok := prop["enumNames"].([]any); ok && len(names) == len(enum) {
+			for _, n := range names {
+				items = append(items, fmt.Sprint(n))
+			}
+		} else {
+			for _, e := range enum {
+				items = append(items, fmt.Sprint(e))
+			}
+		}
+		idx, _, err := (&promptui.Select{Label: label, Items: items}).Run()
+		if err != nil {
+			return nil, err
+		}
+		return enum[idx], nil
+	}
+	if typ == "boolean" {
+		items := []string{"Yes", "No"}
+		cursor := 1
+		if def, ok := prop["default"].(bool); ok && def {
+			cursor = 0
+		}
+		idx, _, err := (&promptui.Select{Label: label, Items: items, CursorPos: cursor}).Run()
+		if err != nil {
+			return nil, err
+		}
+		return idx == 0, nil
+	}
+
+	prompt := &promptui.Prompt{Label: label}
+	if def, ok := prop["default"]; ok {
+		prompt.Default = fmt.Sprint(def)
+	}
+	prompt.Validate = func(s string) error {
+		if s == "" {
+			if required {
+				return errors.New("required")
+			}
+			return nil
+		}
+		_, err := parseElicitValue(typ, s)
+		return err
+	}
+	s, err := prompt.Run()
+	if err != nil {
+		return nil, err
+	}
+	if s == "" {
+		return nil, nil
+	}
+	return parseElicitValue(typ, s)
+}
+
+// parseElicitValue parses the input of the user for the type of the
+// property.
+func parseElicitValue(typ, s string) (any, error) {
+	switch typ {
+	case "integer":
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	case "number":
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	}
+	return s, nil
+}
@@ -0,0 +1,235 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmuk/sylvan/pkg/chat/parts"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// resourceToPart converts the contents of a resource to a part. Text
+// contents are embedded in a text part, and images are attached as they
+// are.
+func resourceToPart(rc *mcp.ResourceContents) *parts.Part {
+	if rc.Blob == nil {
+		return &parts.Part{
+			Text: fmt.Sprintf("Here attaches the resource %s: ```%s```", rc.URI, rc.Text),
+		}
+	}
+	b := &parts.Blob{
+		Data:     rc.Blob,
+		MimeType: rc.MIMEType,
+		Filename: rc.URI,
+	}
+	if strings.HasPrefix(rc.MIMEType, "image/") {
+		return &parts.Part{Image: b}
+	}
+	return &parts.Part{File: b}
+}
+
+func (mt *MCPTool) capabilities(ctx context.Context) (*mcp.ClientSession, *mcp.ServerCapabilities, error) {
+	sess, err := mt.getSession(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	caps := &mcp.ServerCapabilities{}
+	if res := sess.InitializeResult(); res != nil && res.Capabilities != nil {
+		caps = res.Capabilities
+	}
+	return sess, caps, nil
+}
+
+// Resources returns the resources of the MCP server. The list is cached
+// until the server notifies the change.
+func (mt *MCPTool) Resources(ctx context.Context) ([]*mcp.Resource, error) {
+	mt.mu.Lock()
+	cached := mt.resources
+	mt.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+	sess, caps, err := mt.capabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources := []*mcp.Resource{}
+	if caps.Resources != nil {
+		for r, err := range sess.Resources(ctx, nil) {
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, r)
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].URI < resources[j].URI
+	})
+	mt.mu.Lock()
+	mt.resources = resources
+	mt.mu.Unlock()
+	return resources, nil
+}
+
+// The timeout to fetch the lists in the background.
+const prefetchTimeout = 30 * time.Second
+
+// Prefetch fetches the resources and the prompts in the background to fill
+// the caches, unless they are already being fetched.
+func (mt *MCPTool) Prefetch() {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if mt.prefetching {
+		return
+	}
+	mt.prefetching = true
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), prefetchTimeout)
+		defer cancel()
+		// The errors are reported when the lists are used.
+		mt.Resources(ctx)
+		mt.Prompts(ctx)
+		mt.mu.Lock()
+		mt.prefetching = false
+		mt.mu.Unlock()
+	}()
+}
+
+// CachedResources returns the cached resources without blocking. When
+// they aren't cached, they are fetched in the background for the later
+// calls.
+func (mt *MCPTool) CachedResources() []*mcp.Resource {
+	mt.mu.Lock()
+	cached := mt.resources
+	mt.mu.Unlock()
+	if cached == nil {
+		mt.Prefetch()
+	}
+	return cached
+}
+
+// ReadResource reads the resource at the URI, and subscribes to its
+// updates when the server supports it.
+func (mt *MCPTool) ReadResource(ctx context.Context, uri string) ([]*parts.Part, error) {
+	sess, caps, err := mt.capabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := sess.ReadResource(ctx, &mcp.ReadResourceParams{URI: uri})
+	if err != nil {
+		return nil, err
+	}
+	var ps []*parts.Part
+	for _, rc := range result.Contents {
+		ps = append(ps, resourceToPart(rc))
+	}
+	if caps.Resources == nil || !caps.Resources.Subscribe {
+		return ps, nil
+	}
+	mt.mu.Lock()
+	subscribed := mt.subscribed[uri]
+	mt.mu.Unlock()
+	if subscribed {
+		return ps, nil
+	}
+	if err := sess.Subscribe(ctx, &mcp.SubscribeParams{URI: uri}); err != nil {
+		return nil, err
+	}
+	mt.mu.Lock()
+	if mt.subscribed == nil {
+		mt.subscribed = map[string]bool{}
+	}
+	mt.subscribed[uri] = true
+	mt.mu.Unlock()
+	return ps, nil
+}
+
+// UpdatedResources returns the URIs of the subscribed resources which are
+// updated since the last call.
+func (mt *MCPTool) UpdatedResources() []string {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	uris := make([]string, 0, len(mt.updated))
+	for uri := range mt.updated {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	mt.updated = nil
+	return uris
+}
+
+// Prompts returns the prompts of the MCP server. The list is cached until
+// the server notifies the change.
+func (mt *MCPTool) Prompts(ctx context.Context) ([]*mcp.Prompt, error) {
+	mt.mu.Lock()
+	cached := mt.prompts
+	mt.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+	sess, caps, err := mt.capabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prompts := []*mcp.Prompt{}
+	if caps.Prompts != nil {
+		for p, err := range sess.Prompts(ctx, nil) {
+			if err != nil {
+				return nil, err
+			}
+			prompts = append(prompts, p)
+		}
+	}
+	sort.Slice(prompts, func(i, j int) bool {
+		return prompts[i].Name < prompts[j].Name
+	})
+	mt.mu.Lock()
+	mt.prompts = prompts
+	mt.mu.Unlock()
+	return prompts, nil
+}
+
+// CachedPrompts returns the cached prompts without blocking. When they
+// aren't cached, they are fetched in the background for the later calls.
+func (mt *MCPTool) CachedPrompts() []*mcp.Prompt {
+	mt.mu.Lock()
+	cached := mt.prompts
+	mt.mu.Unlock()
+	if cached == nil {
+		mt.Prefetch()
+	}
+	return cached
+}
+
+// GetPrompt returns the messages of the prompt with the arguments, as the
+// text and the other parts.
+func (mt *MCPTool) GetPrompt(ctx context.Context, name string, args map[string]string) (string, []*parts.Part, error) {
+	sess, err := mt.getSession(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	result, err := sess.GetPrompt(ctx, &mcp.GetPromptParams{
+		Name:      name,
+		Arguments: args,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	var texts []string
+	var ps []*parts.Part
+	for _, msg := range result.Messages {
+		p, ok := contentToPart(msg.Content)
+		if !ok {
+			continue
+		}
+		if p.Text != "" {
+			texts = append(texts, p.Text)
+		} else {
+			ps = append(ps, p)
+		}
+	}
+	return strings.Join(texts, "\n\n"), ps, nil
+}
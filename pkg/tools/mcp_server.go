@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jmuk/sylvan/pkg/chat/parts"
+	"github.com/jmuk/sylvan/pkg/session"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// mcpServer runs the tool calls from the MCP clients.
+type mcpServer struct {
+	s      *session.Session
+	runner *ToolRunner
+	elicit bool
+
+	// The tool calls are run one at a time, since they share the session
+	// and the confirmations on the terminal.
+	mu sync.Mutex
+}
+
+// NewMCPServer creates a MCP server exposing the tools, which run in the
+// session. When elicit is true, the confirmations of the tools are asked
+// to the client through the elicitation instead of the terminal.
+func NewMCPServer(s *session.Session, defs []ToolDefinition, elicit bool) (*mcp.Server, error) {
+	runner, err := NewToolRunner(defs)
+	if err != nil {
+		return nil, err
+	}
+	ms := &mcpServer{s: s, runner: runner, elicit: elicit}
+	server := mcp.NewServer(&mcp.Implementation{
+		Name:    "sylvan",
+		Version: "v0.0.1",
+	}, nil)
+	for _, d := range defs {
+		server.AddTool(&mcp.Tool{
+			Name:         d.Name(),
+			Description:  d.Description(),
+			InputSchema:  d.RequestSchema(),
+			OutputSchema: d.ResponseSchema(),
+		}, ms.handler(d.Name()))
+	}
+	return server, nil
+}
+
+func (ms *mcpServer) handler(name string) mcp.ToolHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		in := map[string]any{}
+		if len(req.Params.Arguments) > 0 {
+			if err := json.Unmarshal(req.Params.Arguments, &in); err != nil {
+				return errorResult(err), nil
+			}
+		}
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+		ctx = ms.s.With(ctx)
+		if ms.elicit {
+			ctx = WithApprover(ctx, &elicitApprover{
+				ss:      req.Session,
+				message: fmt.Sprintf("Allow %s with %s?", name, req.Params.Arguments),
+			})
+		}
+		out, ps, err := ms.runner.Run(ctx, name, in)
+		if te := (*ToolError)(nil); errors.As(err, &te) {
+			return errorResult(err), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(out)
+		if err != nil {
+			return nil, err
+		}
+		// The structured content is also sent as text for the clients
+		// which don't support it.
+		result := &mcp.CallToolResult{
+			Content:           []mcp.Content{&mcp.TextContent{Text: string(data)}},
+			StructuredContent: out,
+		}
+		for _, p := range ps {
+			if c, ok := partToContent(p); ok {
+				result.Content = append(result.Content, c)
+			}
+		}
+		return result, nil
+	}
+}
+
+// errorResult returns the result to report the error to the model.
+func errorResult(err error) *mcp.CallToolResult {
+	return &mcp.CallToolResult{
+		IsError: true,
+		Content: []mcp.Content{&mcp.TextContent{Text: err.Error()}},
+	}
+}
+
+// partToContent converts the part to the content of MCP; the reverse of
+// contentToPart.
+func partToContent(p *parts.Part) (mcp.Content, bool) {
+	switch {
+	case p.Text != "":
+		return &mcp.TextContent{Text: p.Text}, true
+	case p.Image != nil:
+		return &mcp.ImageContent{MIMEType: p.Image.MimeType, Data: p.Image.Data}, true
+	case p.Audio != nil:
+		return &mcp.AudioContent{MIMEType: p.Audio.MimeType, Data: p.Audio.Data}, true
+	case p.File != nil:
+		return &mcp.EmbeddedResource{Resource: &mcp.ResourceContents{
+			URI:      "file:///" + p.File.Filename,
+			MIMEType: p.File.MimeType,
+			Blob:     p.File.Data,
+		}}, true
+	}
+	return nil, false
+}
+
+// elicitApprover asks the MCP client to approve the tool calls through the
+// elicitation.
+type elicitApprover struct {
+	ss      *mcp.ServerSession
+	message string
+}
+
+func (ea *elicitApprover) supported() bool {
+	params := ea.ss.InitializeParams()
+	return params != nil && params.Capabilities != nil && params.Capabilities.Elicitation != nil
+}
+
+// Confirm implements Approver.
+func (ea *elicitApprover) Confirm(ctx context.Context) (bool, error) {
+	if !ea.supported() {
+		return false, &ToolError{errors.New("the tool needs the user's approval, but the client doesn't support the elicitation")}
+	}
+	result, err := ea.ss.Elicit(ctx, &mcp.ElicitParams{
+		Message: ea.message,
+		RequestedSchema: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.Action == "accept", nil
+}
+
+// Reason implements Approver.
+func (ea *elicitApprover) Reason(ctx context.Context) (string, error) {
+	result, err := ea.ss.Elicit(ctx, &mcp.ElicitParams{
+		Message: "Tell me why",
+		RequestedSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"reason": map[string]any{"type": "string"},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	reason, _ := result.Content["reason"].(string)
+	return reason, nil
+}
@@ -5,22 +5,142 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/andreyvit/diff"
-	"github.com/manifoldco/promptui"
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 type modification struct {
-	Offset  int64  `json:"offset" jsonschema:"required,description=the offset starting point in bytes to be changed"`
-	Length  int64  `json:"length" jsonschema:"required,description=the length of the parts to be modified"`
-	Replace string `json:"replace" jsonschema:"required,description=the new content to replace the previous content"`
+	Offset      int64  `json:"offset,omitempty" jsonschema:"description=the offset starting point in bytes to be changed. Ignored when start_line is specified"`
+	Length      int64  `json:"length,omitempty" jsonschema:"description=the length in bytes of the parts to be modified. Ignored when start_line is specified"`
+	StartLine   int    `json:"start_line,omitempty" jsonschema:"description=the line number (1-based) where the change starts; an alternative to offset"`
+	StartColumn int    `json:"start_column,omitempty" jsonschema:"description=the column (1-based; in characters) where the change starts. The default is 1"`
+	EndLine     int    `json:"end_line,omitempty" jsonschema:"description=the line number (1-based) where the change ends (exclusive with end_column). The default is start_line"`
+	EndColumn   int    `json:"end_column,omitempty" jsonschema:"description=the column (1-based; in characters) where the change ends; the character at this position is kept. The default is start_column; use column 1 of the next line to replace whole lines"`
+	Replace     string `json:"replace" jsonschema:"required,description=the new content to replace the previous content"`
 }
 
 type modifyFileRequest struct {
 	Filename      string         `json:"filename" jsonschema:"required"`
-	Modifications []modification `json:"modifications" jsonschema:"description=the list of changes to make"`
-	Diff          string         `json:"diff" jsonschema:"description=the diff format string describing the change to make. Either of diff or modifications need to be specified"`
+	Modifications []modification `json:"modifications,omitempty" jsonschema:"description=the list of changes to make. All positions refer to the original content, and the changes must not overlap"`
+	Diff          string         `json:"diff,omitempty" jsonschema:"description=the diff-match-patch format string describing the change to make. Either of diff or modifications need to be specified"`
+}
+
+// positionToOffset converts the line and the column (both 1-based, column
+// in characters) into the byte offset in the content.
+func positionToOffset(content string, line, column int) (int, error) {
+	if line < 1 || column < 1 {
+		return 0, fmt.Errorf("invalid position %d:%d", line, column)
+	}
+	offset := 0
+	for l := 1; l < line; l++ {
+		i := strings.IndexByte(content[offset:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d is out of range; the file has %d lines", line, l)
+		}
+		offset += i + 1
+	}
+	for c := 1; c < column; c++ {
+		if offset >= len(content) || content[offset] == '\n' {
+			return 0, fmt.Errorf("column %d is out of range at line %d", column, line)
+		}
+		_, size := utf8.DecodeRuneInString(content[offset:])
+		offset += size
+	}
+	return offset, nil
+}
+
+// byteRange returns the range of the modification in bytes.
+func (m modification) byteRange(content string) (int, int, error) {
+	if m.StartLine == 0 {
+		start := int(m.Offset)
+		end := start + int(m.Length)
+		if m.Offset < 0 || start > len(content) {
+			return 0, 0, fmt.Errorf("invalid offset %d; the file has %d bytes", m.Offset, len(content))
+		}
+		if m.Length < 0 || end > len(content) {
+			return 0, 0, fmt.Errorf("invalid length %d; the file has %d bytes", m.Length, len(content))
+		}
+		if start < len(content) && !utf8.RuneStart(content[start]) {
+			return 0, 0, fmt.Errorf("offset %d is in the middle of a character", start)
+		}
+		if end < len(content) && !utf8.RuneStart(content[end]) {
+			return 0, 0, fmt.Errorf("the end %d is in the middle of a character", end)
+		}
+		return start, end, nil
+	}
+	startColumn := max(m.StartColumn, 1)
+	endLine := m.EndLine
+	if endLine == 0 {
+		endLine = m.StartLine
+	}
+	endColumn := m.EndColumn
+	if endColumn == 0 {
+		endColumn = startColumn
+	}
+	start, err := positionToOffset(content, m.StartLine, startColumn)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := positionToOffset(content, endLine, endColumn)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("the end %d:%d is before the start %d:%d", endLine, endColumn, m.StartLine, startColumn)
+	}
+	return start, end, nil
+}
+
+// applyModifications applies the modifications; their positions are
+// relative to the original content.
+func applyModifications(content string, mods []modification) (string, error) {
+	type resolved struct {
+		start, end int
+		index      int
+	}
+	ranges := make([]resolved, 0, len(mods))
+	for i, m := range mods {
+		start, end, err := m.byteRange(content)
+		if err != nil {
+			return "", fmt.Errorf("%d-th modification: %w", i, err)
+		}
+		ranges = append(ranges, resolved{start: start, end: end, index: i})
+	}
+	// Stable, so that multiple insertions at the same place keep the order.
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+	result := &strings.Builder{}
+	pos := 0
+	for i, r := range ranges {
+		if r.start < pos {
+			return "", fmt.Errorf("%d-th modification overlaps with %d-th modification", r.index, ranges[i-1].index)
+		}
+		result.WriteString(content[pos:r.start])
+		result.WriteString(mods[r.index].Replace)
+		pos = r.end
+	}
+	result.WriteString(content[pos:])
+	return result.String(), nil
+}
+
+// applyDiff applies the diff in the diff-match-patch format.
+func applyDiff(content, d string) (string, error) {
+	dmp := diffmatchpatch.New()
+	patches, err := dmp.PatchFromText(d)
+	if err != nil {
+		return "", err
+	}
+	content, applied := dmp.PatchApply(patches, content)
+	for i, a := range applied {
+		if !a {
+			return "", fmt.Errorf("failed to apply %d-th hunk of diff", i)
+		}
+	}
+	return content, nil
 }
 
 func (ft *FileTools) modifyFile(ctx context.Context, req modifyFileRequest) (string, error) {
@@ -30,6 +150,10 @@ func (ft *FileTools) modifyFile(ctx context.Context, req modifyFileRequest) (str
 		logger.Error("Both modifications and diff are empty")
 		return "", &ToolError{errors.New("both modifications and diff are empty")}
 	}
+	if len(req.Modifications) > 0 && req.Diff != "" {
+		logger.Error("Both modifications and diff are specified")
+		return "", &ToolError{errors.New("only one of modifications and diff can be specified")}
+	}
 	fmt.Printf("Modifying %s\n", req.Filename)
 	root, err := ft.getRoot()
 	if err != nil {
@@ -42,66 +166,23 @@ func (ft *FileTools) modifyFile(ctx context.Context, req modifyFileRequest) (str
 	}
 	strData := string(data)
 
-	if len(req.Modifications) == 0 {
-		type modWithIndex struct {
-			modification
-			index int
-		}
-		sortedMods := make([]modWithIndex, 0, len(req.Modifications))
-		for i, m := range req.Modifications {
-			sortedMods = append(sortedMods, modWithIndex{
-				modification: m,
-				index:        i,
-			})
-		}
-		sort.Slice(sortedMods, func(i, j int) bool {
-			m1 := sortedMods[i]
-			m2 := sortedMods[j]
-			return m1.Offset > m2.Offset
-		})
-
-		// modify from the last for the simplicity of the edit.
-		for _, m := range sortedMods {
-			mlog := logger.With(
-				"index", m.index, "offset", m.Offset,
-				"length", m.Length, "replace", m.Replace)
-			mlog.Debug("Modification")
-			if len(strData) < int(m.Offset) || m.Offset < 0 {
-				mlog.Error("Invalid modification")
-				return "", &ToolError{
-					fmt.Errorf("invalid offset %d at %d-th modification", m.Offset, m.index),
-				}
-			}
-			start := int(m.Offset)
-			end := start + int(m.Length)
-			if end > len(strData) {
-				mlog.Error("Incalid modification")
-				return "", &ToolError{
-					fmt.Errorf("invalid length %d at %d-th modification", m.Length, m.index),
-				}
-			}
-			strData = strData[:start] + m.Replace + strData[end:]
+	if len(req.Modifications) > 0 {
+		strData, err = applyModifications(strData, req.Modifications)
+		if err != nil {
+			logger.Error("Invalid modification", "error", err)
+			return "", &ToolError{err}
 		}
 	} else {
-		dmp := diffmatchpatch.New()
-		patches, err := dmp.PatchFromText(req.Diff)
+		strData, err = applyDiff(strData, req.Diff)
 		if err != nil {
-			logger.Error("Failed to create patches from diff", "error", err)
+			logger.Error("Failed to apply the diff", "error", err)
 			return "", &ToolError{err}
 		}
-		var applied []bool
-		strData, applied = dmp.PatchApply(patches, strData)
-		for i, a := range applied {
-			if !a {
-				logger.Error("Failed to apply hunk", "hunk-id", i)
-				return "", &ToolError{fmt.Errorf("failed to apply %d-th hunk of diff", i)}
-			}
-		}
 	}
 
 	withNewContent := false
 	fmt.Println(diff.LineDiff(string(data), strData))
-	answer, err := confirm()
+	answer, err := confirm(ctx)
 	if err != nil {
 		logger.Error("Failed to confirm", "error", err)
 		return "", err
@@ -115,13 +196,16 @@ func (ft *FileTools) modifyFile(ctx context.Context, req modifyFileRequest) (str
 		withNewContent = true
 	} else if answer != confirmationYes {
 		logger.Error("User declined")
-		msg, err := (&promptui.Prompt{Label: "Tell me why"}).Run()
+		msg, err := declineReason(ctx)
 		if err != nil {
 			return "", err
 		}
 		return "", &ToolError{fmt.Errorf("user declined to accept the change: `%s`", msg)}
 	}
 
+	if err := ft.snapshot(ctx, root, req.Filename); err != nil {
+		return "", err
+	}
 	if err := root.WriteFile(req.Filename, []byte(strData), 0644); err != nil {
 		logger.Error("Failed to write the file", "error", err)
 		return "", &ToolError{err}
@@ -0,0 +1,196 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func TestApplyModifications(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		mods    []modification
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "offset",
+			content: "hello world",
+			mods:    []modification{{Offset: 6, Length: 5, Replace: "there"}},
+			want:    "hello there",
+		},
+		{
+			name:    "insert",
+			content: "hello world",
+			mods:    []modification{{Offset: 5, Replace: ","}},
+			want:    "hello, world",
+		},
+		{
+			name:    "inserts at the same offset",
+			content: "hello world",
+			mods: []modification{
+				{Offset: 5, Replace: "A"},
+				{Offset: 5, Replace: "B"},
+				{Offset: 5, Replace: "C"},
+			},
+			want: "helloABC world",
+		},
+		{
+			name:    "out of order",
+			content: "hello world",
+			mods: []modification{
+				{Offset: 6, Length: 5, Replace: "there"},
+				{Offset: 0, Length: 5, Replace: "HELLO"},
+			},
+			want: "HELLO there",
+		},
+		{
+			name:    "overlap",
+			content: "hello world",
+			mods: []modification{
+				{Offset: 0, Length: 5, Replace: "a"},
+				{Offset: 3, Length: 4, Replace: "b"},
+			},
+			wantErr: "overlaps",
+		},
+		{
+			name:    "offset out of range",
+			content: "hello",
+			mods:    []modification{{Offset: 6, Replace: "!"}},
+			wantErr: "invalid offset",
+		},
+		{
+			name:    "length out of range",
+			content: "hello",
+			mods:    []modification{{Offset: 3, Length: 3, Replace: "!"}},
+			wantErr: "invalid length",
+		},
+		{
+			name:    "offset in the middle of a character",
+			content: "aéb",
+			mods:    []modification{{Offset: 2, Length: 1, Replace: "e"}},
+			wantErr: "middle of a character",
+		},
+		{
+			name:    "end in the middle of a character",
+			content: "aéb",
+			mods:    []modification{{Offset: 1, Length: 1, Replace: "e"}},
+			wantErr: "middle of a character",
+		},
+		{
+			name:    "line and column",
+			content: "one\ntwo\nthree\n",
+			mods:    []modification{{StartLine: 2, StartColumn: 1, EndLine: 2, EndColumn: 4, Replace: "TWO"}},
+			want:    "one\nTWO\nthree\n",
+		},
+		{
+			name:    "default end column",
+			content: "one\ntwo\nthree\n",
+			mods:    []modification{{StartLine: 2, StartColumn: 2, Replace: "X"}},
+			want:    "one\ntXwo\nthree\n",
+		},
+		{
+			name:    "default end column with end line",
+			content: "one\ntwo\nthree\n",
+			mods:    []modification{{StartLine: 1, StartColumn: 2, EndLine: 3, Replace: "X"}},
+			want:    "oXhree\n",
+		},
+		{
+			name:    "whole lines",
+			content: "one\ntwo\nthree\nfour\n",
+			mods:    []modification{{StartLine: 2, EndLine: 4, EndColumn: 1, Replace: "2\n3\n"}},
+			want:    "one\n2\n3\nfour\n",
+		},
+		{
+			name:    "multibyte columns",
+			content: "aé b\n",
+			mods:    []modification{{StartLine: 1, StartColumn: 3, EndLine: 1, EndColumn: 4, Replace: "_"}},
+			want:    "aé_b\n",
+		},
+		{
+			name:    "line out of range",
+			content: "one\ntwo\n",
+			mods:    []modification{{StartLine: 5, Replace: "x"}},
+			wantErr: "line 5 is out of range",
+		},
+		{
+			name:    "column out of range",
+			content: "one\ntwo\n",
+			mods:    []modification{{StartLine: 1, StartColumn: 10, Replace: "x"}},
+			wantErr: "column 10 is out of range",
+		},
+		{
+			name:    "end before start",
+			content: "one\ntwo\n",
+			mods:    []modification{{StartLine: 2, EndLine: 1, EndColumn: 1, Replace: "x"}},
+			wantErr: "before the start",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := applyModifications(tc.content, tc.mods)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestApplyDiff(t *testing.T) {
+	dmp := diffmatchpatch.New()
+	makeDiff := func(from, to string) string {
+		return dmp.PatchToText(dmp.PatchMake(from, to))
+	}
+	for _, tc := range []struct {
+		name    string
+		content string
+		diff    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "applied",
+			content: "the quick brown fox\njumps over the lazy dog\n",
+			diff:    makeDiff("the quick brown fox\njumps over the lazy dog\n", "the quick red fox\njumps over the lazy dog\n"),
+			want:    "the quick red fox\njumps over the lazy dog\n",
+		},
+		{
+			name:    "hunk fails",
+			content: "hello world\n",
+			diff:    makeDiff("something completely different\n", "something entirely different\n"),
+			wantErr: true,
+		},
+		{
+			name:    "invalid diff",
+			content: "hello world\n",
+			diff:    "@@ invalid @@\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := applyDiff(tc.content, tc.diff)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
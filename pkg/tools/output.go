@@ -0,0 +1,121 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jmuk/sylvan/pkg/session"
+)
+
+const (
+	// Command outputs longer than this are truncated, keeping the head
+	// and the tail.
+	maxCommandOutput = 16 * 1024
+	// The size of the head kept in a truncated output; the rest of
+	// maxCommandOutput is used for the tail.
+	commandOutputHead = 4 * 1024
+	// The max number of the lines read_command_output returns at once.
+	maxOutputPageLines = 500
+)
+
+// capOutput truncates a long command output keeping the head and the
+// tail, and stores the full output in the session so that the agent can
+// read the omitted part with read_command_output.
+func capOutput(ctx context.Context, output string) string {
+	if len(output) <= maxCommandOutput {
+		return output
+	}
+	headEnd := commandOutputHead
+	if i := strings.LastIndexByte(output[:headEnd], '\n'); i >= 0 {
+		headEnd = i + 1
+	}
+	for headEnd > 0 && !utf8.RuneStart(output[headEnd]) {
+		headEnd--
+	}
+	tailStart := len(output) - (maxCommandOutput - commandOutputHead)
+	if i := strings.IndexByte(output[tailStart:], '\n'); i >= 0 && i < len(output)-tailStart-1 {
+		tailStart += i + 1
+	}
+	for tailStart < len(output) && !utf8.RuneStart(output[tailStart]) {
+		tailStart++
+	}
+	headLines := strings.Count(output[:headEnd], "\n")
+	omittedLines := strings.Count(output[headEnd:tailStart], "\n")
+	notice := fmt.Sprintf("... [%d lines (%d bytes) omitted", omittedLines, tailStart-headEnd)
+	if s, ok := session.FromContext(ctx); ok {
+		if id, err := s.SaveOutput(output); err != nil {
+			getLogger(ctx).Error("Failed to save the output", "error", err)
+		} else {
+			notice += fmt.Sprintf(
+				"; the full output is stored as output_id %q, read lines %d-%d with read_command_output to see the omitted part",
+				id, headLines+1, headLines+omittedLines+1)
+		}
+	}
+	return output[:headEnd] + notice + "] ...\n" + output[tailStart:]
+}
+
+type readOutputRequest struct {
+	OutputID  string `json:"output_id" jsonschema:"required,description=the ID of the stored output"`
+	StartLine int    `json:"start_line,omitempty" jsonschema:"description=the first line (1-based) to read; defaults to 1"`
+	EndLine   int    `json:"end_line,omitempty" jsonschema:"description=the last line (1-based and inclusive) to read"`
+}
+
+type readOutputResponse struct {
+	Content    string `json:"content" jsonschema:"description=the lines of the output, each prefixed by its line number and ': '"`
+	StartLine  int    `json:"start_line,omitempty" jsonschema:"description=the line number of the first line in the content"`
+	EndLine    int    `json:"end_line,omitempty" jsonschema:"description=the line number of the last line in the content"`
+	TotalLines int    `json:"total_lines" jsonschema:"description=the number of the lines in the output"`
+	Continues  string `json:"continues,omitempty" jsonschema:"description=set when the content is truncated; describes how to read the rest"`
+}
+
+func (et *ExecTool) readOutput(ctx context.Context, req readOutputRequest) (*readOutputResponse, error) {
+	logger := getLogger(ctx)
+	logger.Debug("Read output")
+	if req.StartLine < 0 || req.EndLine < 0 {
+		return nil, &ToolError{errors.New("start_line and end_line must not be negative")}
+	}
+	s, ok := session.FromContext(ctx)
+	if !ok {
+		return nil, &ToolError{errors.New("no outputs are stored")}
+	}
+	output, err := s.ReadOutput(req.OutputID)
+	if err != nil {
+		logger.Error("Failed to read the output", "error", err)
+		return nil, &ToolError{err}
+	}
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	resp := &readOutputResponse{TotalLines: len(lines)}
+	start := max(req.StartLine, 1)
+	if start > len(lines) {
+		return nil, &ToolError{fmt.Errorf("start_line %d is beyond the end of the output (%d lines)", start, len(lines))}
+	}
+	end := len(lines)
+	if req.EndLine > 0 {
+		end = min(req.EndLine, end)
+	}
+	if end < start {
+		return nil, &ToolError{fmt.Errorf("end_line %d is before start_line %d", end, start)}
+	}
+	if end-start+1 > maxOutputPageLines {
+		end = start + maxOutputPageLines - 1
+	}
+	b := &strings.Builder{}
+	for i := start; i <= end; i++ {
+		line := lines[i-1]
+		if b.Len()+len(line) > maxCommandOutput && i > start {
+			end = i - 1
+			break
+		}
+		fmt.Fprintf(b, "%d: %s\n", i, truncateLine(line, maxCommandOutput))
+	}
+	resp.Content = b.String()
+	resp.StartLine = start
+	resp.EndLine = end
+	if end < len(lines) && (req.EndLine == 0 || end < req.EndLine) {
+		resp.Continues = fmt.Sprintf("the content is truncated; read the rest with start_line=%d", end+1)
+	}
+	return resp, nil
+}
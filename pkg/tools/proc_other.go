@@ -0,0 +1,29 @@
+//go:build !unix
+
+package tools
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on this platform; only the process itself
+// is killed when the context is done.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the process.
+func killProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// exitSignal returns the name of the signal which terminated the
+// process; always empty on this platform.
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
+
+// shellSignal returns the name of the signal for the exit status reported
+// by a shell; always empty on this platform.
+func shellSignal(code int) string {
+	return ""
+}
@@ -0,0 +1,46 @@
+//go:build unix
+
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command run in its own process group, so
+// that the whole group including the grandchildren is killed when the
+// context is done.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process)
+	}
+}
+
+// killProcessGroup kills the process group led by the process.
+func killProcessGroup(p *os.Process) error {
+	if err := syscall.Kill(-p.Pid, syscall.SIGKILL); err != nil {
+		return p.Kill()
+	}
+	return nil
+}
+
+// exitSignal returns the name of the signal which terminated the
+// process, or empty if it exited normally.
+func exitSignal(state *os.ProcessState) string {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal().String()
+	}
+	return ""
+}
+
+// shellSignal returns the name of the signal for the exit status reported
+// by a shell, which is 128 plus the signal number when the command is
+// killed by a signal, or empty for the other statuses.
+func shellSignal(code int) string {
+	if code <= 128 || code >= 128+65 {
+		return ""
+	}
+	return syscall.Signal(code - 128).String()
+}
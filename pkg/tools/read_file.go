@@ -1,80 +1,171 @@
 package tools
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jmuk/sylvan/pkg/chat/parts"
 )
 
+// Images larger than this are not sent to the agent.
+const maxImageSize = 5 << 20
+
+// The image types which can be attached to the response.
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
 type readFileRequest struct {
-	Filename string `json:"filename" jsonschema:"required"`
-	Offset   int64  `json:"offset" jsonschema:"title=the offset,description=the offset in bytes to start reading"`
-	Length   int64  `json:"length" jsonschema:"description=the total length to read in bytes or -1 to read them all"`
+	Filename  string `json:"filename" jsonschema:"required"`
+	StartLine int    `json:"start_line,omitempty" jsonschema:"description=the first line (1-based) to read; defaults to 1"`
+	EndLine   int    `json:"end_line,omitempty" jsonschema:"description=the last line (1-based and inclusive) to read; defaults to the end of the file"`
 }
 
 type readFileResponse struct {
-	Content     string `json:"content" jsonschema:"description=the content to be read"`
+	Content     string `json:"content" jsonschema:"description=the lines of the file, each prefixed by its line number and ': '"`
+	StartLine   int    `json:"start_line,omitempty" jsonschema:"description=the line number of the first line in the content"`
+	EndLine     int    `json:"end_line,omitempty" jsonschema:"description=the line number of the last line in the content"`
+	TotalLines  int    `json:"total_lines" jsonschema:"description=the number of the lines in the file"`
 	TotalLength int64  `json:"total_length" jsonschema:"description=the total length of the file in bytes"`
+	Continues   string `json:"continues,omitempty" jsonschema:"description=set when the content is truncated; describes how to read the rest"`
+
+	image *parts.Blob
+}
+
+func (r *readFileResponse) toolParts() []*parts.Part {
+	if r.image == nil {
+		return nil
+	}
+	return []*parts.Part{{Image: r.image}}
 }
 
 func (ft *FileTools) readFile(ctx context.Context, req readFileRequest) (*readFileResponse, error) {
 	logger := getLogger(ctx)
 	logger.Debug("Reading file")
+	if req.StartLine < 0 || req.EndLine < 0 {
+		return nil, &ToolError{errors.New("start_line and end_line must not be negative")}
+	}
+	if req.EndLine > 0 && req.EndLine < req.StartLine {
+		return nil, &ToolError{fmt.Errorf("end_line %d is before start_line %d", req.EndLine, req.StartLine)}
+	}
 	fmt.Println("Reading", req.Filename)
 	root, err := ft.getRoot()
 	if err != nil {
 		return nil, err
 	}
-	if req.Offset == 0 && req.Length <= 0 {
-		data, err := root.ReadFile(req.Filename)
-		if err != nil {
-			logger.Error("Failed to read", "error", err)
-			return nil, &ToolError{err}
-		}
-		return &readFileResponse{
-			Content:     string(data),
-			TotalLength: int64(len(data)),
-		}, nil
-	}
-
 	s, err := root.Stat(req.Filename)
 	if err != nil {
 		logger.Error("Failed to stat", "error", err)
 		return nil, &ToolError{err}
 	}
-	totalLength := s.Size()
-
+	if s.IsDir() {
+		return nil, &ToolError{fmt.Errorf("%s is a directory", req.Filename)}
+	}
 	f, err := root.Open(req.Filename)
 	if err != nil {
 		logger.Error("Failed to open", "error", err)
 		return nil, &ToolError{err}
 	}
 	defer f.Close()
-	if req.Offset > 0 {
-		if _, err := f.Seek(req.Offset, 0); err != nil {
-			logger.Error("Failed to seek", "error", err)
-			return nil, &ToolError{err}
-		}
+
+	head := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		logger.Error("Failed to read", "error", err)
+		return nil, &ToolError{err}
 	}
-	if req.Length <= 0 {
-		data, err := io.ReadAll(f)
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	if imageTypes[contentType] {
+		if s.Size() > maxImageSize {
+			return nil, &ToolError{fmt.Errorf("%s is an image of %d bytes, too large to read", req.Filename, s.Size())}
+		}
+		rest, err := io.ReadAll(f)
 		if err != nil {
 			logger.Error("Failed to read", "error", err)
 			return nil, &ToolError{err}
 		}
 		return &readFileResponse{
-			Content:     string(data),
-			TotalLength: totalLength,
+			Content:     fmt.Sprintf("the %s image is attached", contentType),
+			TotalLength: s.Size(),
+			image: &parts.Blob{
+				Data:     append(head, rest...),
+				MimeType: contentType,
+				Filename: req.Filename,
+			},
 		}, nil
 	}
-	buf := make([]byte, req.Length)
-	if n, err := f.Read(buf); err != nil && err != io.EOF {
-		logger.Error("Failed to read", "error", err)
-		return nil, &ToolError{err}
-	} else {
-		return &readFileResponse{
-			Content:     string(buf[:n]),
-			TotalLength: totalLength,
-		}, nil
+	if isBinary(head) {
+		logger.Info("Binary file", "content_type", contentType)
+		return nil, &ToolError{fmt.Errorf("%s is a binary file (%s, %d bytes) and can't be read as text", req.Filename, contentType, s.Size())}
+	}
+
+	maxLines, maxBytes := ft.config.ReadLimits()
+	start := max(req.StartLine, 1)
+	resp := &readFileResponse{TotalLength: s.Size()}
+	b := &strings.Builder{}
+	truncated := false
+	// The line number of the line cut by maxBytes.
+	cutLine := 0
+	r := bufio.NewReader(io.MultiReader(bytes.NewReader(head), f))
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			resp.TotalLines++
+			lineNo := resp.TotalLines
+			if lineNo >= start && (req.EndLine == 0 || lineNo <= req.EndLine) && !truncated {
+				text := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+				entry := fmt.Sprintf("%d: %s\n", lineNo, text)
+				switch {
+				case lineNo-start >= maxLines:
+					truncated = true
+				case b.Len()+len(entry) > maxBytes && resp.EndLine > 0:
+					truncated = true
+				case len(entry) > maxBytes:
+					// A single huge line, such as minified code.
+					fmt.Fprintf(b, "%d: %s\n", lineNo, truncateLine(text, maxBytes))
+					resp.EndLine = lineNo
+					cutLine = lineNo
+					truncated = true
+				default:
+					b.WriteString(entry)
+					resp.EndLine = lineNo
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error("Failed to read", "error", err)
+			return nil, &ToolError{err}
+		}
+	}
+	if start > resp.TotalLines && start > 1 {
+		return nil, &ToolError{fmt.Errorf("start_line %d is beyond the end of the file (%d lines)", start, resp.TotalLines)}
+	}
+	resp.Content = b.String()
+	if resp.EndLine > 0 {
+		resp.StartLine = start
+	}
+	if truncated {
+		resp.Continues = fmt.Sprintf(
+			"the content is truncated; the file continues after line %d (%d lines in total). Read the rest with start_line=%d",
+			resp.EndLine, resp.TotalLines, resp.EndLine+1)
+		if cutLine > 0 {
+			resp.Continues = fmt.Sprintf(
+				"line %d is longer than %d bytes and only its beginning is shown; the rest of the line can't be read with read_file. ",
+				cutLine, maxBytes) + resp.Continues
+		}
 	}
+	return resp, nil
 }
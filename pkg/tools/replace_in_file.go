@@ -0,0 +1,121 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/andreyvit/diff"
+)
+
+type replacement struct {
+	OldString  string `json:"old_string" jsonschema:"required,description=the exact text to be replaced, including the whitespace and the indentation. It must appear exactly once in the file unless replace_all is true; include surrounding lines to make it unique"`
+	NewString  string `json:"new_string" jsonschema:"required,description=the text to replace old_string with"`
+	ReplaceAll bool   `json:"replace_all,omitempty" jsonschema:"description=replace all the occurrences of old_string"`
+}
+
+type replaceInFileRequest struct {
+	Filename string        `json:"filename" jsonschema:"required"`
+	Edits    []replacement `json:"edits" jsonschema:"required,description=the list of the replacements applied in the order; each one applies to the result of the previous ones. Either all of them are applied or none"`
+}
+
+// lineNumbers returns the line numbers (1-based) of the occurrences of s.
+func lineNumbers(content, s string) []int {
+	var results []int
+	pos := 0
+	for {
+		i := strings.Index(content[pos:], s)
+		if i < 0 {
+			return results
+		}
+		pos += i
+		results = append(results, strings.Count(content[:pos], "\n")+1)
+		pos += len(s)
+	}
+}
+
+func applyReplacements(content string, edits []replacement) (string, error) {
+	for i, e := range edits {
+		if e.OldString == "" {
+			return "", fmt.Errorf("edit %d: old_string is empty", i)
+		}
+		if e.OldString == e.NewString {
+			return "", fmt.Errorf("edit %d: old_string and new_string are the same", i)
+		}
+		count := strings.Count(content, e.OldString)
+		if count == 0 {
+			return "", fmt.Errorf("edit %d: old_string is not found in the file; read the file again to check the exact text", i)
+		}
+		if count > 1 && !e.ReplaceAll {
+			return "", fmt.Errorf(
+				"edit %d: old_string appears %d times at lines %v; add more surrounding text to make it unique or set replace_all",
+				i, count, lineNumbers(content, e.OldString))
+		}
+		if e.ReplaceAll {
+			content = strings.ReplaceAll(content, e.OldString, e.NewString)
+		} else {
+			content = strings.Replace(content, e.OldString, e.NewString, 1)
+		}
+	}
+	return content, nil
+}
+
+func (ft *FileTools) replaceInFile(ctx context.Context, req replaceInFileRequest) (string, error) {
+	logger := getLogger(ctx)
+	logger.Info("Replace in file")
+	if len(req.Edits) == 0 {
+		logger.Error("No edits")
+		return "", &ToolError{errors.New("edits are empty")}
+	}
+	fmt.Printf("Modifying %s\n", req.Filename)
+	root, err := ft.getRoot()
+	if err != nil {
+		return "", err
+	}
+	data, err := root.ReadFile(req.Filename)
+	if err != nil {
+		logger.Error("Error reading file", "error", err)
+		return "", &ToolError{err}
+	}
+	strData, err := applyReplacements(string(data), req.Edits)
+	if err != nil {
+		logger.Error("Failed to apply the edits", "error", err)
+		return "", &ToolError{err}
+	}
+
+	withNewContent := false
+	fmt.Println(diff.LineDiff(string(data), strData))
+	answer, err := confirm(ctx)
+	if err != nil {
+		logger.Error("Failed to confirm", "error", err)
+		return "", err
+	}
+	if answer == confirmationEdit {
+		strData, err = userEdit(logger, req.Filename, strData)
+		if err != nil {
+			logger.Error("Failed to confirm", "error", err)
+			return "", err
+		}
+		withNewContent = true
+	} else if answer != confirmationYes {
+		logger.Error("User declined")
+		msg, err := declineReason(ctx)
+		if err != nil {
+			return "", err
+		}
+		return "", &ToolError{fmt.Errorf("user declined to accept the change: `%s`", msg)}
+	}
+
+	if err := ft.snapshot(ctx, root, req.Filename); err != nil {
+		return "", err
+	}
+	if err := root.WriteFile(req.Filename, []byte(strData), 0644); err != nil {
+		logger.Error("Failed to write the file", "error", err)
+		return "", &ToolError{err}
+	}
+	if withNewContent {
+		return strData, nil
+	}
+	return "", nil
+}
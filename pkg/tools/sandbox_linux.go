@@ -0,0 +1,83 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jmuk/sylvan/pkg/config"
+)
+
+// prlimitArgs returns the arguments of prlimit for the resource limits.
+func prlimitArgs(c config.SandboxConfig) []string {
+	var args []string
+	if c.MaxMemoryMB > 0 {
+		args = append(args, "--as="+strconv.Itoa(c.MaxMemoryMB<<20))
+	}
+	if c.MaxCPUSeconds > 0 {
+		args = append(args, "--cpu="+strconv.Itoa(c.MaxCPUSeconds))
+	}
+	if c.MaxFileSizeMB > 0 {
+		args = append(args, "--fsize="+strconv.Itoa(c.MaxFileSizeMB<<20))
+	}
+	if c.MaxProcesses > 0 {
+		args = append(args, "--nproc="+strconv.Itoa(c.MaxProcesses))
+	}
+	return args
+}
+
+func expandHome(p string) (string, error) {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, p[1:]), nil
+}
+
+// sandboxCommand wraps the command line arguments to run them in the
+// sandbox, using the namespaces through bubblewrap. The dir is the
+// project directory and workDir is the working directory in it. The .git
+// directory stays read-only, since git runs outside of the sandbox (e.g.
+// /commit), and its config or hooks could run arbitrary commands there.
+func sandboxCommand(c config.SandboxConfig, dir, workDir string, args []string) ([]string, error) {
+	bwrap, err := exec.LookPath("bwrap")
+	if err != nil {
+		return nil, fmt.Errorf("the sandbox requires bubblewrap (bwrap): %w", err)
+	}
+	wrapped := []string{
+		bwrap,
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+		"--bind", dir, dir,
+		"--ro-bind-try", filepath.Join(dir, ".git"), filepath.Join(dir, ".git"),
+	}
+	for _, p := range c.WritablePaths {
+		p, err := expandHome(p)
+		if err != nil {
+			return nil, err
+		}
+		wrapped = append(wrapped, "--bind-try", p, p)
+	}
+	if !c.AllowNetwork {
+		wrapped = append(wrapped, "--unshare-net")
+	}
+	wrapped = append(wrapped, "--unshare-pid", "--unshare-ipc", "--die-with-parent", "--new-session", "--chdir", workDir, "--")
+	if limits := prlimitArgs(c); len(limits) > 0 {
+		prlimit, err := exec.LookPath("prlimit")
+		if err != nil {
+			return nil, fmt.Errorf("the resource limits require prlimit: %w", err)
+		}
+		wrapped = append(wrapped, prlimit)
+		wrapped = append(wrapped, limits...)
+		wrapped = append(wrapped, "--")
+	}
+	return append(wrapped, args...), nil
+}
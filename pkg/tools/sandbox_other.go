@@ -0,0 +1,15 @@
+//go:build !linux
+
+package tools
+
+import (
+	"errors"
+
+	"github.com/jmuk/sylvan/pkg/config"
+)
+
+// sandboxCommand wraps the command line arguments to run them in the
+// sandbox. It is not supported on this platform yet.
+func sandboxCommand(c config.SandboxConfig, dir, workDir string, args []string) ([]string, error) {
+	return nil, errors.New("the sandbox is supported only on Linux")
+}
@@ -1,17 +1,35 @@
 package tools
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io/fs"
-	"path/filepath"
+	"path"
 	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// The default max number of the results.
+	defaultMaxSearchResults = 100
+	// Files larger than this are not searched with grep.
+	maxSearchFileSize = 1 << 20
+	// Longer lines are truncated in the results.
+	maxSearchLineLength = 300
+	// The number of bytes examined to detect binary files.
+	binarySniffSize = 8000
 )
 
 type searchFilesRequest struct {
-	PathPattern string `json:"path_pattern" jsonschema:"description=the glob pattern to match with the file names -- always start from the current directory"`
-	Grep        string `json:"grep" jsonschema:"The regular expression to match with the content of the files"`
+	PathPattern    string `json:"path_pattern,omitempty" jsonschema:"description=the glob pattern to match with the file paths from the current directory. '**' matches any number of directories; a pattern without '/' matches the base names at any depth (e.g. '*.go')"`
+	Grep           string `json:"grep,omitempty" jsonschema:"description=the regular expression (RE2 syntax) to match with the lines of the files"`
+	IgnoreCase     bool   `json:"ignore_case,omitempty" jsonschema:"description=match grep case-insensitively"`
+	ContextLines   int    `json:"context_lines,omitempty" jsonschema:"description=the number of the lines shown before and after each matched line"`
+	MaxResults     int    `json:"max_results,omitempty" jsonschema:"description=the max number of the files or the matched lines to return; defaults to 100"`
+	IncludeIgnored bool   `json:"include_ignored,omitempty" jsonschema:"description=also search the files excluded by .gitignore or .sylvanignore"`
 }
 
 type fileEntry struct {
@@ -19,8 +37,77 @@ type fileEntry struct {
 	IsDir bool   `json:"is_dir" jsonschema:"description=true if it is a directory"`
 }
 
+type searchMatch struct {
+	Path string `json:"path" jsonschema:"description=the path from the current directory"`
+	Line int    `json:"line" jsonschema:"description=the line number (1-based) of the matched line"`
+	Text string `json:"text" jsonschema:"description=the matched line and its context lines prefixed by the line numbers; ':' follows the number of a matched line and '-' follows a context line"`
+}
+
 type searchFilesResponse struct {
-	Files []fileEntry `json:"files"`
+	Files     []fileEntry   `json:"files,omitempty" jsonschema:"description=the matched files when grep is not specified"`
+	Matches   []searchMatch `json:"matches,omitempty" jsonschema:"description=the matched lines when grep is specified"`
+	Truncated string        `json:"truncated,omitempty" jsonschema:"description=set when the results are capped"`
+	Skipped   []string      `json:"skipped,omitempty" jsonschema:"description=the files matching path_pattern but not searched with grep because they are larger than 1MiB"`
+}
+
+// isBinary returns true if the data looks like the content of a binary
+// file.
+func isBinary(data []byte) bool {
+	if len(data) > binarySniffSize {
+		data = data[:binarySniffSize]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// truncateLine cuts the line to the limit in bytes, keeping the UTF-8
+// sequences intact.
+func truncateLine(line string, limit int) string {
+	if len(line) <= limit {
+		return line
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	return line[:cut] + "... (line truncated)"
+}
+
+// pathMatcher returns the function to match the path with the pattern.
+func pathMatcher(pattern string) (func(string) bool, error) {
+	pattern = strings.TrimPrefix(pattern, "./")
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return nil, err
+	}
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+	if !strings.Contains(pattern, "/") {
+		return func(p string) bool {
+			return matchGlob(pattern, p[strings.LastIndex(p, "/")+1:])
+		}, nil
+	}
+	return func(p string) bool { return matchGlob(pattern, p) }, nil
+}
+
+// grepLines returns the matched lines in the content with the context.
+func grepLines(content string, re *regexp.Regexp, contextLines int) []searchMatch {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	var results []searchMatch
+	for i, line := range lines {
+		if !re.MatchString(line) {
+			continue
+		}
+		b := &strings.Builder{}
+		for j := max(i-contextLines, 0); j <= min(i+contextLines, len(lines)-1); j++ {
+			sep := "-"
+			if j == i {
+				sep = ":"
+			}
+			fmt.Fprintf(b, "%d%s%s\n", j+1, sep, truncateLine(lines[j], maxSearchLineLength))
+		}
+		results = append(results, searchMatch{Line: i + 1, Text: b.String()})
+	}
+	return results
 }
 
 func (ft *FileTools) searchFile(ctx context.Context, req searchFilesRequest) (*searchFilesResponse, error) {
@@ -29,11 +116,25 @@ func (ft *FileTools) searchFile(ctx context.Context, req searchFilesRequest) (*s
 	if req.PathPattern == "" && req.Grep == "" {
 		return nil, &ToolError{errors.New("either path_pattern or grep needs to be specified")}
 	}
+	if req.ContextLines < 0 || req.MaxResults < 0 {
+		return nil, &ToolError{errors.New("context_lines and max_results must not be negative")}
+	}
+	maxResults := req.MaxResults
+	if maxResults == 0 {
+		maxResults = defaultMaxSearchResults
+	}
+	matchPath, err := pathMatcher(req.PathPattern)
+	if err != nil {
+		logger.Error("Failed to parse path_pattern", "error", err)
+		return nil, &ToolError{err}
+	}
 	var contentMatch *regexp.Regexp
 	if req.Grep != "" {
-		fmt.Printf("Searching for %s\n", req.PathPattern)
-		var err error
-		contentMatch, err = regexp.Compile(req.Grep)
+		expr := req.Grep
+		if req.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+		contentMatch, err = regexp.Compile(expr)
 		if err != nil {
 			logger.Error("Failed to parse grep", "error", err)
 			return nil, &ToolError{err}
@@ -43,62 +144,70 @@ func (ft *FileTools) searchFile(ctx context.Context, req searchFilesRequest) (*s
 	if err != nil {
 		return nil, err
 	}
-	if req.PathPattern != "" {
+	switch {
+	case req.PathPattern == "":
+		fmt.Printf("Searching with %s\n", req.Grep)
+	case req.Grep == "":
+		fmt.Printf("Searching for %s\n", req.PathPattern)
+	default:
 		fmt.Printf("Searching for %s with %s\n", req.PathPattern, req.Grep)
-		files, err := fs.Glob(root.FS(), req.PathPattern)
+	}
+
+	resp := &searchFilesResponse{}
+	m := newIgnoreMatcher(root.FS())
+	m.includeIgnored = req.IncludeIgnored
+	errCapped := errors.New("capped")
+	err = m.walk(".", func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
-			logger.Error("Failed to glob", "error", err)
-			return nil, &ToolError{err}
+			logger.Warn("Failed to walk", "path", p, "error", err)
+			return nil
 		}
-		resp := &searchFilesResponse{
-			Files: make([]fileEntry, 0, len(files)),
+		if p == "." || !matchPath(p) {
+			return nil
 		}
-		for _, file := range files {
-			if contentMatch != nil {
-				data, err := root.ReadFile(file)
-				if err != nil {
-					return nil, &ToolError{err}
-				}
-				if !contentMatch.Match(data) {
-					continue
-				}
-			}
-			stat, err := root.Stat(file)
-			if err != nil {
-				logger.Error("Failed to stat", "filename", file, "error", err)
-				return nil, &ToolError{err}
+		if contentMatch == nil {
+			if len(resp.Files) >= maxResults {
+				return errCapped
 			}
-			resp.Files = append(resp.Files, fileEntry{
-				Path:  file,
-				IsDir: stat.IsDir(),
-			})
+			resp.Files = append(resp.Files, fileEntry{Path: p, IsDir: d.IsDir()})
+			return nil
 		}
-		logger.Debug("Matched files", "number_of_files", len(resp.Files))
-		return resp, nil
-	}
-
-	resp := &searchFilesResponse{}
-	fmt.Printf("Searching with %s\n", req.Grep)
-	err = filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
 		if err != nil {
-			return err
+			logger.Warn("Failed to stat file", "filename", p, "error", err)
+			return nil
+		}
+		if info.Size() > maxSearchFileSize {
+			logger.Debug("Skipping the large file", "path", p)
+			resp.Skipped = append(resp.Skipped, p)
+			return nil
 		}
-		data, err := root.ReadFile(path)
+		data, err := root.ReadFile(p)
 		if err != nil {
-			logger.Error("Failed to read file", "filename", path, "error", err)
-			return err
+			logger.Warn("Failed to read file", "filename", p, "error", err)
+			return nil
 		}
-		if contentMatch.Match(data) {
-			resp.Files = append(resp.Files, fileEntry{
-				Path:  path,
-				IsDir: d.IsDir(),
-			})
+		if isBinary(data) {
+			return nil
+		}
+		for _, match := range grepLines(string(data), contentMatch, req.ContextLines) {
+			if len(resp.Matches) >= maxResults {
+				return errCapped
+			}
+			match.Path = p
+			resp.Matches = append(resp.Matches, match)
 		}
 		return nil
 	})
-	if err != nil {
-		logger.Error("os.Walk failed", "error", err)
+	if errors.Is(err, errCapped) {
+		resp.Truncated = fmt.Sprintf("more results exist beyond the first %d; narrow down path_pattern or grep, or increase max_results", maxResults)
+	} else if err != nil {
+		logger.Error("Walk failed", "error", err)
 		return nil, &ToolError{err}
 	}
+	logger.Debug("Search done", "files", len(resp.Files), "matches", len(resp.Matches))
 	return resp, nil
 }
@@ -0,0 +1,217 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errShellExited is returned when the shell process exited while running
+// a command, e.g. by `exit`.
+var errShellExited = errors.New("the shell exited")
+
+// persistentShell is a shell process which keeps running across the
+// commands, so that the working directory and the environment variables
+// set by a command are kept for the next ones.
+//
+// The commands are written to the standard input of the shell, followed
+// by the commands to print a random sentinel with the exit code, so that
+// the output of each command can be separated.
+type persistentShell struct {
+	mu sync.Mutex
+	// newCmd creates the command to start the shell.
+	newCmd func() (*exec.Cmd, error)
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *bufio.Reader
+	done   chan struct{}
+}
+
+func newPersistentShell(newCmd func() (*exec.Cmd, error)) *persistentShell {
+	return &persistentShell{newCmd: newCmd}
+}
+
+func (s *persistentShell) start() error {
+	cmd, err := s.newCmd()
+	if err != nil {
+		return err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	s.cmd = cmd
+	s.stdin = stdin
+	s.stdout = bufio.NewReader(stdout)
+	s.stderr = bufio.NewReader(stderr)
+	s.done = done
+	return nil
+}
+
+// stop kills the shell process if it's running.
+func (s *persistentShell) stop() {
+	if s.cmd == nil {
+		return
+	}
+	s.stdin.Close()
+	killProcessGroup(s.cmd.Process)
+	<-s.done
+	s.cmd = nil
+}
+
+func quoteShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func newSentinel() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "__sylvan_" + hex.EncodeToString(b) + "__"
+}
+
+// readUntil copies the output to w until the sentinel appears, and
+// returns the rest of the line after the sentinel.
+func readUntil(r *bufio.Reader, sentinel string, w io.Writer) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if i := strings.Index(line, sentinel); i >= 0 {
+			if _, err := io.WriteString(w, line[:i]); err != nil {
+				return "", err
+			}
+			return strings.TrimSpace(line[i+len(sentinel):]), nil
+		}
+		if line != "" {
+			if _, err := io.WriteString(w, line); err != nil {
+				return "", err
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// shellScript returns the shell script to run the command line and print the
+// sentinel. The working directory and the environment variables in the
+// options are applied in a subshell, so that they don't affect the
+// following commands.
+func shellScript(commandLine string, opts commandOptions, stdin, sentinel string) string {
+	body := "eval " + quoteShell(commandLine)
+	if opts.dir != "" || len(opts.env) > 0 {
+		prefix := &strings.Builder{}
+		if opts.dir != "" {
+			fmt.Fprintf(prefix, "cd %s && ", quoteShell(opts.dir))
+		}
+		for _, name := range sortedKeys(opts.env) {
+			fmt.Fprintf(prefix, "export %s=%s && ", name, quoteShell(opts.env[name]))
+		}
+		body = "(" + prefix.String() + body + ")"
+	}
+	// /dev/null prevents the command from consuming the following lines
+	// as its input.
+	input := "< /dev/null\n"
+	if stdin != "" {
+		if !strings.HasSuffix(stdin, "\n") {
+			stdin += "\n"
+		}
+		input = fmt.Sprintf("<<'%s'\n%s%s\n", sentinel, stdin, sentinel)
+	}
+	return fmt.Sprintf(
+		"{ %s\n} %sprintf '%%s%%d\\n' '%s' $?\nprintf '%%s\\n' '%s' >&2\n",
+		body, input, sentinel, sentinel)
+}
+
+// run runs the command line in the shell, and returns the exit code.
+// When the context is done, the shell is killed and restarted on the
+// next run.
+func (s *persistentShell) run(ctx context.Context, commandLine string, opts commandOptions, stdin string, stdout, stderr io.Writer) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == nil {
+		if err := s.start(); err != nil {
+			return 0, err
+		}
+	}
+	sentinel := newSentinel()
+	// eval keeps the shell alive on syntax errors.
+	if _, err := io.WriteString(s.stdin, shellScript(commandLine, opts, stdin, sentinel)); err != nil {
+		s.stop()
+		return 0, errors.Join(errShellExited, err)
+	}
+
+	type result struct {
+		rest string
+		err  error
+	}
+	outCh := make(chan result, 1)
+	errCh := make(chan result, 1)
+	stdoutReader, stderrReader := s.stdout, s.stderr
+	go func() {
+		rest, err := readUntil(stdoutReader, sentinel, stdout)
+		outCh <- result{rest, err}
+	}()
+	go func() {
+		_, err := readUntil(stderrReader, sentinel, stderr)
+		errCh <- result{err: err}
+	}()
+	var out, errOut result
+	for received := 0; received < 2; received++ {
+		select {
+		case out = <-outCh:
+		case errOut = <-errCh:
+		case <-ctx.Done():
+			s.stop()
+			// Wait closes the pipes, so the readers stop soon; they must
+			// not write to stdout and stderr after returning.
+			for ; received < 2; received++ {
+				select {
+				case <-outCh:
+				case <-errCh:
+				}
+			}
+			return 0, ctx.Err()
+		}
+	}
+	if out.err != nil || errOut.err != nil {
+		s.stop()
+		return 0, errShellExited
+	}
+	code, err := strconv.Atoi(out.rest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse the exit code %q: %w", out.rest, err)
+	}
+	return code, nil
+}
+
+// reset kills the shell; a new one starts on the next run.
+func (s *persistentShell) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+}
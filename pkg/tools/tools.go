@@ -4,7 +4,6 @@ package tools
 import (
 	"context"
 	"fmt"
-	"log"
 	"log/slog"
 
 	"github.com/jmuk/sylvan/pkg/chat/parts"
@@ -24,18 +23,24 @@ func getLogger(ctx context.Context) *slog.Logger {
 	return logger.(*slog.Logger)
 }
 
+// IsAlnum returns true for the characters [a-zA-Z0-9].
+func IsAlnum(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')
+}
+
 // ToolRunner keeps the list of the tools and accepts the tool
 // requests and conducts their invocations.
 type ToolRunner struct {
 	defsMap map[string]ToolDefinition
 }
 
-// NewToolRunner creates a new ToolRunner instance.
+// NewToolRunner creates a new ToolRunner instance. It fails when the
+// names of the tools are duplicated.
 func NewToolRunner(defs []ToolDefinition) (*ToolRunner, error) {
 	m := make(map[string]ToolDefinition, len(defs))
 	for _, d := range defs {
 		if _, ok := m[d.Name()]; ok {
-			log.Printf("name %s duplicated, overwritten", d.Name())
+			return nil, fmt.Errorf("tool name %s is duplicated", d.Name())
 		}
 		m[d.Name()] = d
 	}
@@ -58,6 +63,7 @@ func (r *ToolRunner) Run(ctx context.Context, name string, in map[string]any) (a
 	}
 
 	ctx = context.WithValue(ctx, loggerKey, l.With("tool_name", name, "request", in))
+	s.StartCheckpoint(name)
 	fmt.Println()
 	return p.process(ctx, in)
 }
@@ -71,11 +77,41 @@ const (
 	confirmationNoAnswer confirmationResult = -1
 )
 
-func confirm() (confirmationResult, error) {
-	return confirmWith(true)
+// Approver asks the user to approve the tool calls in place of the
+// terminal, e.g. through the MCP client when sylvan runs as a MCP server.
+type Approver interface {
+	// Confirm asks whether to proceed the tool call.
+	Confirm(ctx context.Context) (bool, error)
+	// Reason asks why the tool call is declined.
+	Reason(ctx context.Context) (string, error)
+}
+
+type approverKeyType struct{}
+
+var approverKey = approverKeyType{}
+
+// WithApprover returns a new context which asks the approver for the
+// confirmations of the tools.
+func WithApprover(ctx context.Context, a Approver) context.Context {
+	return context.WithValue(ctx, approverKey, a)
+}
+
+func confirm(ctx context.Context) (confirmationResult, error) {
+	return confirmWith(ctx, true)
 }
 
-func confirmWith(canEdit bool) (confirmationResult, error) {
+func confirmWith(ctx context.Context, canEdit bool) (confirmationResult, error) {
+	if a, ok := ctx.Value(approverKey).(Approver); ok {
+		// The approvers can't edit the content by themselves.
+		approved, err := a.Confirm(ctx)
+		if err != nil {
+			return confirmationNoAnswer, err
+		}
+		if approved {
+			return confirmationYes, nil
+		}
+		return confirmationNo, nil
+	}
 	items := []string{"Yes", "No"}
 	if canEdit {
 		items = append(items, "No / edit by myself")
@@ -91,3 +127,11 @@ func confirmWith(canEdit bool) (confirmationResult, error) {
 	}
 	return confirmationResult(idx), nil
 }
+
+// declineReason asks the user why the tool call is declined.
+func declineReason(ctx context.Context) (string, error) {
+	if a, ok := ctx.Value(approverKey).(Approver); ok {
+		return a.Reason(ctx)
+	}
+	return (&promptui.Prompt{Label: "Tell me why"}).Run()
+}
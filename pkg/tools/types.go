@@ -38,6 +38,12 @@ type ToolDefinition interface {
 	process(ctx context.Context, in map[string]any) (any, []*parts.Part, error)
 }
 
+// partsProvider is implemented by the responses which carry additional
+// parts, such as images, along with the response object.
+type partsProvider interface {
+	toolParts() []*parts.Part
+}
+
 type toolDefinition[Req any, Resp any] struct {
 	name        string
 	description string
@@ -112,10 +118,14 @@ func (d *toolDefinition[Req, Resp]) process(ctx context.Context, in map[string]a
 	if err != nil {
 		return nil, nil, err
 	}
+	var ps []*parts.Part
+	if pp, ok := any(resp).(partsProvider); ok {
+		ps = pp.toolParts()
+	}
 	if v := reflect.ValueOf(resp); v.CanConvert(reflect.TypeFor[string]()) {
 		return map[string]any{
 			d.respName: v.Convert(reflect.TypeFor[string]()).String(),
-		}, nil, nil
+		}, ps, nil
 	}
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
@@ -127,5 +137,5 @@ func (d *toolDefinition[Req, Resp]) process(ctx context.Context, in map[string]a
 		logger.Error("Failed to unmarshal output", "error", err)
 		return nil, nil, err
 	}
-	return out, nil, nil
+	return out, ps, nil
 }